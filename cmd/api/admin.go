@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+)
+
+// unlockUserHandler clears the failed login attempts and lockout of a user account
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.LoginAttempts.DeleteAllForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditAccountUnlocked, app.requestActor(r), user.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user account unlocked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUsersHandler lists the users using query parameters (if any)
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Name, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"users":    users,
+		"metadata": metadata,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler returns a user along with their roles and permissions
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// updateUserStatusHandler activates, deactivates, suspends or unsuspends a user
+func (app *application) updateUserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+		Suspended *bool `json:"suspended"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+
+	if input.Suspended != nil {
+		user.Suspended = *input.Suspended
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// sign a suspended user out everywhere
+	if user.Suspended {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.invalidateUser(user.ID)
+
+	app.recordAuditEvent(r, data.AuditUserStatusChanged, app.requestActor(r), user.ID, map[string]interface{}{
+		"activated": user.Activated,
+		"suspended": user.Suspended,
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantUserPermissionsHandler adds permissions to a user
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	// the permissions held beforehand, so the user isn't notified about ones they already had
+	before, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+
+	app.recordAuditEvent(r, data.AuditPermissionsGranted, app.requestActor(r), user.ID, map[string]interface{}{"codes": codes})
+
+	app.notifyPermissionsAdded(r, user, before)
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// revokeUserPermissionsHandler removes permissions from a user
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+
+	app.recordAuditEvent(r, data.AuditPermissionsRevoked, app.requestActor(r), user.ID, map[string]interface{}{"codes": codes})
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// forceUserPasswordResetHandler invalidates a user's password and sessions, and emails them a password reset token
+func (app *application) forceUserPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := user.Password.SetUnusable()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	// OAuth clients must be re-authorized after a password change
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeOAuthRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(data.ScopePasswordRest, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(user.ID, 24*time.Hour, data.ScopePasswordRest)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "admin_password_reset.html", mailer.AdminPasswordResetData{
+			Name:               user.Name,
+			PasswordResetToken: token.Plaintext,
+		})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditForcedPasswordReset, app.requestActor(r), user.ID, nil)
+
+	env := envelope{
+		"message": "the user's password has been reset and an email will be sent to them containing instructions to set a new one.",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam looks up the user from the id URL parameter, sending the error response and returning false if that fails
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// readPermissionCodes reads and validates the permission codes in the request body, sending the error response and returning false if that fails
+func (app *application) readPermissionCodes(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Codes) >= 1, "codes", "must contain at least 1 permission code")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+
+	for _, code := range input.Codes {
+		v.Check(known.Include(code), "codes", "must only contain known permission codes")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.Codes, true
+}
+
+// writeUserPermissions sends a user along with their current roles and permissions
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// impersonateUserHandler issues a short-lived token which lets an admin act as a user, to reproduce what they see.
+// Admins can't be impersonated, so that impersonation can't be used to borrow another admin's identity.
+func (app *application) impersonateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	v := validator.New()
+
+	v.Check(user.ID != admin.ID, "user_id", "must not be your own account")
+	v.Check(!user.Suspended, "user_id", "must not be a suspended account")
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v.Check(!permissions.Include("users:admin"), "user_id", "must not be an admin account")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.NewImpersonation(user.ID, admin.ID, 15*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditImpersonationStarted, admin.ID, user.ID, map[string]interface{}{
+		"expiry": token.Expiry,
+	})
+
+	app.logger.PrintInfo("impersonation started", map[string]string{
+		"admin_id": strconv.FormatInt(admin.ID, 10),
+		"user_id":  strconv.FormatInt(user.ID, 10),
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
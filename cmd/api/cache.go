@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/cache"
+	"github.com/blessedmadukoma/greenlight/internal/data"
+)
+
+// authentication holds the user and token behind an authenticated request
+type authentication struct {
+	user  data.User
+	token data.Token
+}
+
+// authCache holds the in-process caches used by the authenticate and requirePermission middleware
+type authCache struct {
+	users       *cache.Cache[[sha256.Size]byte, authentication] // keyed on the token hash, so plaintext tokens aren't kept in memory
+	permissions *cache.Cache[int64, data.Permissions]
+}
+
+func newAuthCache(cfg config) *authCache {
+	return &authCache{
+		users:       cache.New[[sha256.Size]byte, authentication](cfg.cache.ttl, cfg.cache.maxEntries),
+		permissions: cache.New[int64, data.Permissions](cfg.cache.ttl, cfg.cache.maxEntries),
+	}
+}
+
+// authenticateToken returns the user and token for a plaintext authentication token, from the cache if possible.
+// Copies are returned on each call so handlers can modify them without affecting the cached values.
+func (app *application) authenticateToken(plaintext string) (*data.User, *data.Token, error) {
+	key := sha256.Sum256([]byte(plaintext))
+
+	if auth, found := app.cache.users.Get(key); found {
+		// the cache TTL can outlive the token, so an expired token is treated as a miss and looked up again
+		if time.Now().Before(auth.token.Expiry) {
+			auth.token.Plaintext = plaintext
+			return &auth.user, &auth.token, nil
+		}
+
+		app.cache.users.Delete(key)
+	}
+
+	token, err := app.models.Tokens.Get(data.ScopeAuthentication, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	auth := authentication{user: *user, token: *token}
+	auth.token.Plaintext = ""
+
+	app.cache.users.Set(key, auth)
+
+	return user, token, nil
+}
+
+// permissionsForUser returns the permissions of a user, reusing those already loaded for the request or cached from a previous one
+func (app *application) permissionsForUser(r *http.Request, userID int64) (data.Permissions, error) {
+	if permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions); ok {
+		return permissions, nil
+	}
+
+	if permissions, found := app.cache.permissions.Get(userID); found {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	app.cache.permissions.Set(userID, permissions)
+
+	return permissions, nil
+}
+
+// invalidateUser removes a user and their permissions from the caches. It must be called whenever a user is updated or their tokens are deleted.
+func (app *application) invalidateUser(userID int64) {
+	app.cache.users.DeleteFunc(func(_ [sha256.Size]byte, auth authentication) bool {
+		return auth.user.ID == userID
+	})
+
+	app.cache.permissions.Delete(userID)
+}
+
+// invalidatePermissions removes a user's permissions from the cache. It must be called whenever permissions or roles are granted to or revoked from the user.
+func (app *application) invalidatePermissions(userID int64) {
+	app.cache.permissions.Delete(userID)
+}
+
+// invalidateClient removes every token issued to an OAuth client from the cache
+func (app *application) invalidateClient(clientID string) {
+	app.cache.users.DeleteFunc(func(_ [sha256.Size]byte, auth authentication) bool {
+		return auth.token.ClientID == clientID
+	})
+}
+
+// invalidateAll empties the caches, for changes which affect an unknown set of users such as editing a role
+func (app *application) invalidateAll() {
+	app.cache.users.Purge()
+	app.cache.permissions.Purge()
+}
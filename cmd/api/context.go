@@ -9,7 +9,11 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	tokenContextKey       = contextKey("token")
+	permissionsContextKey = contextKey("permissions")
+)
 
 // contextSetUser adds the user information to the request context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -26,3 +30,31 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetToken adds the authentication token used for the request to the request context
+func (app *application) contextSetToken(r *http.Request, token *data.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken returns the authentication token from the request context, or nil for anonymous requests
+func (app *application) contextGetToken(r *http.Request) *data.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*data.Token)
+	return token
+}
+
+// contextSetPermissions adds the permissions of the authenticated user to the request context
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the permissions of the authenticated user from the request context.
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	if !ok {
+		panic("missing permissions value in request context")
+	}
+
+	return permissions
+}
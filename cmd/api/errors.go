@@ -2,6 +2,8 @@ package main
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError is a helper that for logging errors
@@ -91,8 +93,44 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// suspendedAccountResponse is a helper to send a 403 Forbidden response when a suspended user tries to access the API
+func (app *application) suspendedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // notPermittedResponse returns a 403 Forbidden response
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// impersonationNotPermittedResponse returns a 403 Forbidden response for actions which can't be taken while impersonating a user
+func (app *application) impersonationNotPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this action is not permitted while impersonating a user"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// tooManyLoginAttemptsResponse is a helper to send a 429 Too Many Requests response when an account or IP is locked out of logging in
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// registrationClosedResponse is a helper to send a 403 Forbidden response when the registration mode doesn't allow the signup
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "registration is closed, accounts can only be created by invitation"
+	if app.config.registration.mode == registrationClosed {
+		message = "registration is closed"
+	}
+
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// invalidSignatureResponse is a helper to send a 401 Unauthorized response when a webhook request isn't correctly signed
+func (app *application) invalidSignatureResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or missing request signature"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+)
+
+// createExportHandler builds a JSON archive of the authenticated user's data in the background and emails them a download token
+func (app *application) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	app.background(func() {
+		err := app.exportUserData(user)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(user.ID)})
+		}
+	})
+
+	env := envelope{
+		"message": "your data export is being prepared, an email will be sent to you containing download instructions.",
+	}
+
+	err := app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exportUserData builds and stores the export archive for a user, then mails them the download token
+func (app *application) exportUserData(user *data.User) error {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	type tokenMetadata struct {
+		Scope  string    `json:"scope"`
+		Expiry time.Time `json:"expiry"`
+	}
+
+	tokenData := []tokenMetadata{}
+	for _, token := range tokens {
+		tokenData = append(tokenData, tokenMetadata{Scope: token.Scope, Expiry: token.Expiry})
+	}
+
+	movies := []*data.Movie{}
+
+	filters := data.Filters{Page: 1, PageSize: 100, Sort: "id", SortSafelist: []string{"id"}}
+
+	for {
+		page, metadata, err := app.models.Movies.GetAll("", []string{}, user.ID, filters)
+		if err != nil {
+			return err
+		}
+
+		movies = append(movies, page...)
+
+		if filters.Page >= metadata.LastPage {
+			break
+		}
+
+		filters.Page++
+	}
+
+	loginAttempts, err := app.models.LoginAttempts.GetAllForEmail(user.Email)
+	if err != nil {
+		return err
+	}
+
+	archive, err := json.Marshal(envelope{
+		"generated_at":          time.Now(),
+		"user":                  user,
+		"roles":                 roles,
+		"permissions":           permissions,
+		"tokens":                tokenData,
+		"movies":                movies,
+		"failed_login_attempts": loginAttempts,
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.models.Transaction(func(tx data.Models) error {
+		err := tx.Exports.Insert(&data.Export{UserID: user.ID, Archive: archive})
+		if err != nil {
+			return err
+		}
+
+		// only the most recent export can be downloaded
+		err = tx.Tokens.DeleteAllForUser(data.ScopeDataExport, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(user.ID, 24*time.Hour, data.ScopeDataExport)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "token_data_export.html", mailer.DataExportData{
+			Name:            user.Name,
+			DataExportToken: token.Plaintext,
+		})
+	})
+}
+
+// showExportHandler returns the latest export archive of the authenticated user, given the download token from the export email
+func (app *application) showExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	tokenPlaintext := app.readString(r.URL.Query(), "token", "")
+
+	if data.ValidateTokenPlaintext(v, tokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tokenUser, err := app.models.Users.GetForToken(data.ScopeDataExport, tokenPlaintext)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if tokenUser == nil || tokenUser.ID != user.ID {
+		v.AddError("token", "invalid or expired data export token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	export, err := app.models.Exports.GetLatestForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="greenlight-export-%d.json"`, export.ID))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
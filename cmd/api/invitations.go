@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+)
+
+// the registration modes set by the -registration flag
+const (
+	registrationOpen       = "open"        // anyone can sign up with POST /v1/users
+	registrationInviteOnly = "invite-only" // accounts can only be created by accepting an invitation
+	registrationClosed     = "closed"      // no new accounts can be created
+)
+
+// createInvitationHandler invites someone to register, with the roles and permissions they'll be given, and emails them the invitation token
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.registration.mode == registrationClosed {
+		app.registrationClosedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email       string   `json:"email"`
+		Roles       []string `json:"roles"`
+		Permissions []string `json:"permissions"`
+		ExpiryDays  *int     `json:"expiry_days"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	invitation := &data.Invitation{
+		Email:       input.Email,
+		Roles:       input.Roles,
+		Permissions: input.Permissions,
+		InvitedBy:   admin.ID,
+	}
+
+	if invitation.Roles == nil {
+		invitation.Roles = []string{}
+	}
+
+	if invitation.Permissions == nil {
+		invitation.Permissions = []string{}
+	}
+
+	expiryDays := 7
+	if input.ExpiryDays != nil {
+		expiryDays = *input.ExpiryDays
+	}
+
+	v := validator.New()
+
+	data.ValidateInvitation(v, invitation)
+	v.Check(expiryDays >= 1 && expiryDays <= 30, "expiry_days", "must be between 1 and 30")
+
+	if len(invitation.Roles) > 0 {
+		err = app.validateRoleNames(v, "roles", invitation.Roles)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range invitation.Permissions {
+		v.Check(known.Include(code), "permissions", "must only contain known permission codes")
+	}
+
+	_, err = app.models.Users.GetByEmail(invitation.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "user with this email address already exists")
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Invitations.Insert(invitation, time.Duration(expiryDays)*24*time.Hour)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(invitation.Email, mailer.DefaultLocale, "invitation.html", mailer.InvitationData{
+			InviterName:     admin.Name,
+			InvitationToken: invitation.Plaintext,
+			Expiry:          invitation.Expiry.UTC().Format(time.RFC1123),
+		})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listInvitationsHandler lists the invitations which haven't been accepted and haven't expired
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := app.models.Invitations.GetAllPending()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteInvitationHandler revokes a pending invitation
+func (app *application) deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Invitations.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invitation successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptInvitationHandler registers and activates the invited user in one step, with the roles and permissions chosen by the admin
+func (app *application) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.registration.mode == registrationClosed {
+		app.registrationClosedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Name           string `json:"name"`
+		Password       string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invitation, err := app.models.Invitations.GetForToken(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the invitation was sent to the email address, so following it proves the user owns it
+	user := &data.User{
+		Name:      input.Name,
+		Email:     invitation.Email,
+		Activated: true,
+		Locale:    mailer.MatchLocale(r.Header.Get("Accept-Language")),
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	roles := invitation.Roles
+	if app.config.registration.defaultRole != "" {
+		roles = append(roles, app.config.registration.defaultRole)
+	}
+
+	// the invitation is claimed, the account created and the grants applied together, so a failure leaves the
+	// invitation pending and no partial account behind
+	err = app.models.Transaction(func(tx data.Models) error {
+		// claiming the invitation first means concurrent requests can't both use it
+		err := tx.Invitations.Accept(invitation)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Roles.AddForUser(user.ID, roles...)
+		if err != nil {
+			return err
+		}
+
+		if len(invitation.Permissions) > 0 {
+			return tx.Permissions.AddForUser(user.ID, invitation.Permissions...)
+		}
+
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditInvitationAccepted, user.ID, user.ID, map[string]interface{}{
+		"invitation_id": invitation.ID,
+		"invited_by":    invitation.InvitedBy,
+		"roles":         roles,
+		"permissions":   invitation.Permissions,
+	})
+
+	// everything the new account holds came from the invitation and the default role
+	app.notifyPermissionsAdded(r, user, nil)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
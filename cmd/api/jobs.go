@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// startJobs schedules the periodic maintenance jobs
+func (app *application) startJobs() {
+	app.runPeriodic("delete_scheduled_users", time.Hour, app.deleteScheduledUsers)
+
+	if app.config.activation.expiryDays > 0 {
+		app.runPeriodic("delete_unactivated_users", time.Hour, app.deleteUnactivatedUsers)
+	}
+
+	if app.config.tokens.sweepInterval > 0 {
+		app.runPeriodic("delete_expired_tokens", app.config.tokens.sweepInterval, app.deleteExpiredTokens)
+	}
+
+	if app.config.login.retention > 0 {
+		app.runPeriodic("delete_expired_login_attempts", time.Hour, app.deleteExpiredLoginAttempts)
+	}
+
+	if app.config.audit.retention > 0 {
+		app.runPeriodic("delete_expired_audit_events", 24*time.Hour, app.deleteExpiredAuditEvents)
+	}
+
+	if app.config.outbox.retention > 0 {
+		app.runPeriodic("delete_finished_emails", 24*time.Hour, app.deleteFinishedEmails)
+	}
+}
+
+// runPeriodic runs fn every interval in a background goroutine until the server starts shutting down.
+// A failing or panicking run is logged and the job carries on with the next tick. Each run holds an advisory lock
+// named after the job, so when several instances are running only one of them runs the job at a time.
+func (app *application) runPeriodic(name string, interval time.Duration, fn func() error) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				app.runJob(name, fn)
+			}
+		}
+	})
+}
+
+// runJob runs a single iteration of a periodic job, recovering from any panic
+func (app *application) runJob(name string, fn func() error) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"job": name})
+		}
+	}()
+
+	ran, err := app.models.Locks.TryRun("job:"+name, fn)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": name})
+		return
+	}
+
+	if !ran {
+		app.logger.PrintInfo("job skipped, running on another instance", map[string]string{"job": name})
+	}
+}
+
+// deleteExpiredTokens deletes expired tokens in batches, so that a large backlog doesn't hold locks on the table for long
+func (app *application) deleteExpiredTokens() error {
+	total, err := app.deleteInBatches(app.config.tokens.sweepBatchSize, app.models.Tokens.DeleteExpired)
+	if err != nil {
+		return err
+	}
+
+	if total > 0 {
+		app.logger.PrintInfo("deleted expired tokens", map[string]string{
+			"count": strconv.FormatInt(total, 10),
+		})
+	}
+
+	return nil
+}
+
+// deleteInBatches calls deleteBatch until it deletes fewer rows than the batch size, and returns the total deleted.
+// It stops early if the server starts shutting down, leaving the rest for the next run of the job.
+func (app *application) deleteInBatches(batchSize int, deleteBatch func(limit int) (int64, error)) (int64, error) {
+	var total int64
+
+	for {
+		deleted, err := deleteBatch(batchSize)
+		if err != nil {
+			return total, err
+		}
+
+		total += deleted
+
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+
+		select {
+		case <-app.shutdown:
+			return total, nil
+		default:
+		}
+	}
+}
+
+// deleteScheduledUsers permanently deletes the accounts whose deletion grace period has ended
+func (app *application) deleteScheduledUsers() error {
+	emails, err := app.models.Users.DeleteScheduled(time.Now())
+	if err != nil {
+		return err
+	}
+
+	return app.cleanUpDeletedUsers("deleted scheduled user accounts", emails)
+}
+
+// deleteUnactivatedUsers deletes the accounts which were never activated, so their email addresses can be registered again
+func (app *application) deleteUnactivatedUsers() error {
+	emails, err := app.models.Users.DeleteUnactivated(time.Now().AddDate(0, 0, -app.config.activation.expiryDays))
+	if err != nil {
+		return err
+	}
+
+	return app.cleanUpDeletedUsers("deleted unactivated user accounts", emails)
+}
+
+// deleteExpiredLoginAttempts deletes the failed logins and ended lockouts older than the retention, which would otherwise
+// pile up for email addresses nobody signs in to successfully
+func (app *application) deleteExpiredLoginAttempts() error {
+	// attempts inside the window still count towards a lockout, so they're kept however short the retention
+	retention := app.config.login.retention
+	if retention < app.config.login.window {
+		retention = app.config.login.window
+	}
+
+	before := time.Now().Add(-retention)
+
+	attempts, err := app.deleteInBatches(1000, func(limit int) (int64, error) {
+		return app.models.LoginAttempts.DeleteBefore(before, limit)
+	})
+	if err != nil {
+		return err
+	}
+
+	lockouts, err := app.deleteInBatches(1000, func(limit int) (int64, error) {
+		return app.models.LoginAttempts.DeleteLockoutsBefore(before, limit)
+	})
+	if err != nil {
+		return err
+	}
+
+	if attempts > 0 || lockouts > 0 {
+		app.logger.PrintInfo("deleted expired login attempts", map[string]string{
+			"attempts": strconv.FormatInt(attempts, 10),
+			"lockouts": strconv.FormatInt(lockouts, 10),
+		})
+	}
+
+	return nil
+}
+
+// cleanUpDeletedUsers removes the remaining state for users deleted by a job, and logs how many were deleted
+func (app *application) cleanUpDeletedUsers(message string, emails []string) error {
+	// login attempts are keyed on the email address rather than the user, so they aren't covered by the foreign keys
+	for _, email := range emails {
+		err := app.models.LoginAttempts.DeleteAllForEmail(email)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(emails) > 0 {
+		// the deleted users may still be cached from their last request
+		app.invalidateAll()
+
+		app.logger.PrintInfo(message, map[string]string{
+			"count": strconv.Itoa(len(emails)),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// mailCatcherTemplate lists the caught emails, newest first, with their plain text and rendered HTML parts
+var mailCatcherTemplate = template.Must(template.New("mail").Parse(`<!doctype html>
+<html>
+<head>
+ <meta charset="utf-8">
+ <title>Greenlight mail catcher</title>
+ <style>
+  body { font-family: sans-serif; margin: 2em; }
+  summary { cursor: pointer; padding: 0.5em 0; }
+  pre { white-space: pre-wrap; background: #f4f4f4; padding: 1em; }
+  iframe { width: 100%; height: 30em; border: 1px solid #ccc; }
+ </style>
+</head>
+<body>
+ <h1>Greenlight mail catcher</h1>
+ <p>The last {{.Size}} emails are kept here instead of being sent. They are also available as JSON from
+  <a href="/debug/mail/messages"><code>/debug/mail/messages</code></a>.</p>
+ {{range .Messages}}
+ <details>
+  <summary>
+   <strong>{{.Subject}}</strong> to {{.To}}, {{.SentAt.Format "2006-01-02 15:04:05"}}
+   (<code>{{.Template}}</code>, {{.Locale}}, <a href="/debug/mail/messages/{{.ID}}">JSON</a>)
+  </summary>
+  <h2>Plain text</h2>
+  <pre>{{.PlainBody}}</pre>
+  <h2>HTML</h2>
+  <iframe sandbox srcdoc="{{.HTMLBody}}"></iframe>
+ </details>
+ {{else}}
+ <p>No emails have been sent yet.</p>
+ {{end}}
+</body>
+</html>
+`))
+
+// showMailCatcherHandler serves the caught emails as an HTML page
+func (app *application) showMailCatcherHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := mailCatcherTemplate.Execute(w, map[string]interface{}{
+		"Size":     app.config.mailer.catcherSize,
+		"Messages": app.catcher.Messages(),
+	})
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// listCaughtMailHandler returns the caught emails, newest first
+func (app *application) listCaughtMailHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"messages": app.catcher.Messages()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCaughtMailHandler returns a single caught email
+func (app *application) showCaughtMailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	msg, ok := app.catcher.Get(id)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": msg}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCaughtMailHandler forgets the caught emails
+func (app *application) deleteCaughtMailHandler(w http.ResponseWriter, r *http.Request) {
+	app.catcher.Reset()
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "caught emails successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/blessedmadukoma/greenlight/internal/data"
 	"github.com/blessedmadukoma/greenlight/internal/jsonlog"
 	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/lib/pq"
 )
@@ -40,24 +41,78 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	mailer struct {
+		backend     string
+		dir         string
+		catcherSize int
+	}
 	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
+		host        string
+		port        int
+		username    string
+		password    string
+		sender      string
+		rate        float64
+		concurrency int
+	}
+	dkim struct {
+		domain   string
+		selector string
+		keyFile  string
 	}
 	cors struct {
 		trustedOrigins []string
 	}
+	login struct {
+		maxAttempts   int
+		ipMaxAttempts int
+		window        time.Duration
+		lockoutBase   time.Duration
+		lockoutMax    time.Duration
+		retention     time.Duration
+	}
+	deletion struct {
+		gracePeriod time.Duration
+	}
+	registration struct {
+		mode        string
+		defaultRole string
+	}
+	activation struct {
+		resendCooldown time.Duration
+		expiryDays     int
+	}
+	cache struct {
+		ttl        time.Duration
+		maxEntries int
+	}
+	tokens struct {
+		sweepInterval  time.Duration
+		sweepBatchSize int
+	}
+	audit struct {
+		retention time.Duration
+	}
+	outbox struct {
+		workers      int
+		maxAttempts  int
+		pollInterval time.Duration
+		retention    time.Duration
+	}
+	webhooks struct {
+		mailSecret string
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config   config
+	logger   *jsonlog.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	catcher  *mailer.CatcherMailer // set when the catcher mailer is used, to serve the caught emails at /debug/mail
+	cache    *authCache
+	wg       sync.WaitGroup
+	shutdown chan struct{} // closed when the server starts shutting down, to stop the periodic jobs
 }
 
 // limiValues retreives the values for the rate limiter from the env
@@ -127,6 +182,18 @@ func main() {
 	flag.StringVar(&cfg.smtp.username, "smtp-username", smtp.username, "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", smtp.password, "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", smtp.sender, "SMTP sender")
+	flag.Float64Var(&cfg.smtp.rate, "smtp-rate", 10, "Maximum messages sent through SMTP per second (0 for no limit)")
+	flag.IntVar(&cfg.smtp.concurrency, "smtp-concurrency", 2, "Number of SMTP connections kept open for sending")
+
+	// set the values for DKIM signing of the mail sent through SMTP
+	flag.StringVar(&cfg.dkim.domain, "dkim-domain", "", "DKIM signing domain, e.g. example.com")
+	flag.StringVar(&cfg.dkim.selector, "dkim-selector", "", "DKIM selector, the public key is published at <selector>._domainkey.<domain>")
+	flag.StringVar(&cfg.dkim.keyFile, "dkim-private-key", "", "PEM file with the DKIM private key, RSA or Ed25519 (signing is disabled if empty)")
+
+	// set the values for the mailer backend
+	flag.StringVar(&cfg.mailer.backend, "mailer", "", "Mailer backend (smtp|file|log|memory|catcher), catcher in development and smtp otherwise if empty")
+	flag.StringVar(&cfg.mailer.dir, "mailer-dir", "./tmp/mail", "Directory the file mailer writes .eml files to")
+	flag.IntVar(&cfg.mailer.catcherSize, "mailer-catcher-size", 100, "Number of emails the catcher mailer keeps for /debug/mail")
 
 	// set the values for the cors
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
@@ -134,6 +201,41 @@ func main() {
 		return nil
 	})
 
+	// set the values for the login brute-force protection
+	flag.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins per account before it is locked")
+	flag.IntVar(&cfg.login.ipMaxAttempts, "login-ip-max-attempts", 50, "Failed logins per IP before it is throttled")
+	flag.DurationVar(&cfg.login.window, "login-window", 15*time.Minute, "Window in which failed logins are counted")
+	flag.DurationVar(&cfg.login.lockoutBase, "login-lockout-base", time.Minute, "Duration of the first account lockout, doubled on each subsequent lockout")
+	flag.DurationVar(&cfg.login.lockoutMax, "login-lockout-max", 24*time.Hour, "Maximum duration of an account lockout")
+	flag.DurationVar(&cfg.login.retention, "login-attempt-retention", 30*24*time.Hour, "Time failed logins and ended lockouts are kept for (0 to keep them forever, at least -login-window otherwise)")
+
+	flag.DurationVar(&cfg.deletion.gracePeriod, "deletion-grace-period", 30*24*time.Hour, "Time before an account scheduled for deletion is permanently deleted")
+
+	flag.StringVar(&cfg.registration.mode, "registration", registrationOpen, "Registration mode (open|invite-only|closed)")
+	flag.StringVar(&cfg.registration.defaultRole, "default-role", "viewer", "Role assigned to new users on signup (empty for none)")
+
+	flag.DurationVar(&cfg.activation.resendCooldown, "activation-resend-cooldown", 5*time.Minute, "Minimum time between activation emails sent to an account")
+	flag.IntVar(&cfg.activation.expiryDays, "activation-expiry-days", 30, "Days after which unactivated accounts are deleted (0 to keep them)")
+
+	// set the values for the authentication cache
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "Time authenticated users and permissions are cached for (0 to disable)")
+	flag.IntVar(&cfg.cache.maxEntries, "cache-max-entries", 10_000, "Maximum number of entries in each authentication cache")
+
+	// set the values for the expired token sweeper
+	flag.DurationVar(&cfg.tokens.sweepInterval, "token-sweep-interval", time.Hour, "Interval between deletions of expired tokens (0 to disable)")
+	flag.IntVar(&cfg.tokens.sweepBatchSize, "token-sweep-batch-size", 1000, "Maximum number of expired tokens deleted per query")
+
+	flag.DurationVar(&cfg.audit.retention, "audit-retention", 365*24*time.Hour, "Time audit events are kept for (0 to keep them forever)")
+
+	// set the values for the email outbox workers
+	flag.IntVar(&cfg.outbox.workers, "outbox-workers", 4, "Number of workers sending emails from the outbox")
+	flag.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 8, "Attempts to send an email before it is given up on")
+	flag.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "Interval between checks of the outbox for emails to send")
+	flag.DurationVar(&cfg.outbox.retention, "outbox-retention", 7*24*time.Hour, "Time sent and dead emails are kept in the outbox for (0 to keep them forever)")
+
+	// set the secret for the mail events webhook
+	flag.StringVar(&cfg.webhooks.mailSecret, "mail-webhook-secret", os.Getenv("MAIL_WEBHOOK_SECRET"), "Shared secret mail event webhook requests are signed with (the webhook is disabled if empty)")
+
 	// version boolean flag
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -147,6 +249,10 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	if !validator.In(cfg.registration.mode, registrationOpen, registrationInviteOnly, registrationClosed) {
+		logger.PrintFatal(fmt.Errorf("invalid registration mode %q", cfg.registration.mode), nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -156,6 +262,30 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	models := data.NewModels(db)
+
+	// fail fast if the default role has been misconfigured, instead of on the first signup
+	if cfg.registration.defaultRole != "" {
+		exists, err := models.Roles.Exists(cfg.registration.defaultRole)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		if !exists {
+			logger.PrintFatal(fmt.Errorf("default role %q does not exist", cfg.registration.defaultRole), nil)
+		}
+	}
+
+	mailBackend, err := newMailer(cfg, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	catcher, _ := mailBackend.(*mailer.CatcherMailer)
+	if catcher != nil {
+		logger.PrintInfo("emails are kept by the mail catcher instead of being sent", map[string]string{"url": "/debug/mail"})
+	}
+
 	expvar.NewString("version").Set(version)
 
 	// publish the number of active goroutines
@@ -173,19 +303,90 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	authCache := newAuthCache(cfg)
+
+	// publish the authentication cache hit/miss counters
+	expvar.Publish("cache", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"users":       authCache.users.Stats(),
+			"permissions": authCache.permissions.Stats(),
+		}
+	}))
+
+	// publish the number of emails waiting in the outbox, and the number given up on
+	expvar.Publish("email_outbox", expvar.Func(func() interface{} {
+		depth, err := models.Outbox.Depth()
+		if err != nil {
+			return err.Error()
+		}
+
+		return depth
+	}))
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   models,
+		mailer:   mailer.WithSuppressions(mailBackend, models.Suppressions),
+		catcher:  catcher,
+		cache:    authCache,
+		shutdown: make(chan struct{}),
 	}
 
+	app.startJobs()
+	app.startOutboxWorkers()
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 }
 
+// newMailer returns the mailer backend chosen with the -mailer flag. If it isn't set, mail never leaves the machine in
+// development, and is sent through SMTP otherwise.
+func newMailer(cfg config, logger *jsonlog.Logger) (mailer.Mailer, error) {
+	backend := cfg.mailer.backend
+	if backend == "" {
+		backend = "smtp"
+		if cfg.env == "development" {
+			backend = "catcher"
+		}
+	}
+
+	switch backend {
+	case "smtp":
+		smtp := mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+
+		if cfg.dkim.keyFile != "" {
+			signer, err := mailer.LoadDKIMSigner(cfg.dkim.domain, cfg.dkim.selector, cfg.dkim.keyFile)
+			if err != nil {
+				return nil, err
+			}
+
+			smtp.SignWith(signer)
+		}
+
+		smtp.Start(cfg.smtp.rate, cfg.smtp.concurrency)
+
+		return smtp, nil
+	case "file":
+		return mailer.NewFile(cfg.mailer.dir, cfg.smtp.sender)
+	case "log":
+		return mailer.NewLog(logger, cfg.smtp.sender), nil
+	case "memory":
+		return mailer.NewMemory(cfg.smtp.sender), nil
+	case "catcher":
+		// the caught emails, tokens included, are served to anyone who can reach the API
+		if cfg.env != "development" {
+			return nil, fmt.Errorf("the catcher mailer can only be used in development")
+		}
+
+		return mailer.NewCatcher(cfg.smtp.sender, cfg.mailer.catcherSize), nil
+	default:
+		return nil, fmt.Errorf("invalid mailer backend %q", backend)
+	}
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -111,7 +111,8 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		authHeader := r.Header.Get("Authorization")
 
-		if authHeader == "" {
+		// the OAuth endpoints authenticate clients and users themselves
+		if authHeader == "" || strings.HasPrefix(r.URL.Path, "/oauth/") {
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
@@ -133,7 +134,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, tokenData, err := app.authenticateToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -144,12 +145,41 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if user.Suspended {
+			app.suspendedAccountResponse(w, r)
+			return
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, tokenData)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// auditImpersonation - write every request made with an impersonation token to the audit log with both identities, and
+// block destructive actions and admin routes (which include every permission change) while impersonating
+func (app *application) auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := app.contextGetToken(r)
+
+		if !token.IsImpersonation() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodDelete || strings.HasPrefix(r.URL.Path, "/v1/admin/") {
+			app.impersonationNotPermittedResponse(w, r)
+			app.recordImpersonatedRequest(r, token, http.StatusForbidden)
+			return
+		}
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		app.recordImpersonatedRequest(r, token, metrics.Code)
+	})
+}
+
 // rateLimit - Global rate limiting
 // func (app *application) rateLimit(next http.Handler) http.Handler {
 // 	// new limiter which allows an average of 2 requests per second, with a maximum of 4 requests in a single 'burst'
@@ -198,22 +228,77 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// requireFirstPartyToken - reject tokens issued to OAuth clients or to admins impersonating the user, for account management
+// routes which should only ever be used by the account holder. It should be wrapped by requireAuthenticatedUser or requireActivatedUser.
+func (app *application) requireFirstPartyToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := app.contextGetToken(r)
+
+		if token.IsImpersonation() {
+			app.impersonationNotPermittedResponse(w, r)
+			return
+		}
+
+		if token.IsOAuth() {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireScope - check that a token issued to an OAuth client was granted the scope, first-party tokens are always allowed.
+// It should be wrapped by requireAuthenticatedUser or requireActivatedUser.
+func (app *application) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := app.contextGetToken(r)
+
+		if token.IsOAuth() && !validator.In(scope, token.OAuthScopes...) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
 // requirePermission - check if the user has the required permission
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAnyPermission([]string{code}, next)
+}
+
+// requireAnyPermission - check if the user has at least one of the given permissions, and add the user's permissions to the request context for the handler's policy checks
+func (app *application) requireAnyPermission(codes []string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.permissionsForUser(r, user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		if !permissions.Include(code) {
+		// a token issued to an OAuth client only carries the permissions covered by its scopes
+		if token := app.contextGetToken(r); token.IsOAuth() {
+			permissions = permissions.Intersect(data.PermissionsForScopes(token.OAuthScopes))
+		}
+
+		permitted := false
+		for _, code := range codes {
+			if permissions.Include(code) {
+				permitted = true
+				break
+			}
+		}
+
+		if !permitted {
 			app.notPermittedResponse(w, r)
 			return
 		}
 
+		r = app.contextSetPermissions(r, permissions)
+
 		next.ServeHTTP(w, r)
 	}
 
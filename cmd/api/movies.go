@@ -25,11 +25,14 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	movie := &data.Movie{
 		Title:   input.Title,
 		Year:    input.Year,
 		Runtime: input.Runtime,
 		Genres:  input.Genres,
+		Owner:   &data.Owner{ID: user.ID, Name: user.Name},
 	}
 
 	v := validator.New()
@@ -107,6 +110,11 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !app.canModifyMovie(app.contextGetUser(r), app.contextGetPermissions(r), movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	// If the request contains a X-Expected-Version header, verify that the movie
 	// version in the database matches the expected version specified in the header.
 	if r.Header.Get("X-Expected-Version") != "" {
@@ -183,6 +191,22 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canModifyMovie(app.contextGetUser(r), app.contextGetPermissions(r), movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	err = app.models.Movies.Delete(id)
 	if err != nil {
 		switch {
@@ -210,8 +234,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 // listMoviesHandler lists the movies using query parameters (if any)
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		Title   string
+		Genres  []string
+		OwnerID int
 		data.Filters
 	}
 
@@ -221,6 +246,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.OwnerID = app.readInt(qs, "owner_id", 0, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
@@ -228,12 +254,14 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 
+	v.Check(input.OwnerID >= 0, "owner_id", "must not be negative")
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, int64(input.OwnerID), input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -248,3 +276,65 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 }
+
+// updateMovieOwnerHandler reassigns a movie to another user
+func (app *application) updateMovieOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	owner, err := app.models.Users.Get(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "must be the id of an existing user")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.Owner = &data.Owner{ID: owner.ID, Name: owner.Name}
+
+	err = app.models.Movies.UpdateOwner(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
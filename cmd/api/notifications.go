@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// sessionRevokeTokenTTL is how long the token in a security notification can be used to sign out of every session
+const sessionRevokeTokenTTL = 7 * 24 * time.Hour
+
+// sendSecurityNotification emails a user about a change to their account, unless they've opted out of the notification.
+// build is given the details of the request and a token to revoke every session, and returns the template data.
+// Failures are logged rather than returned, so that a problem sending the email doesn't fail a request which has already succeeded.
+func (app *application) sendSecurityNotification(r *http.Request, user *data.User, notification, templateFile string, build func(mailer.SecurityDetails) interface{}) {
+	err := app.enqueueSecurityNotification(r, user, notification, templateFile, build)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"notification": notification,
+			"user_id":      strconv.FormatInt(user.ID, 10),
+		})
+	}
+}
+
+func (app *application) enqueueSecurityNotification(r *http.Request, user *data.User, notification, templateFile string, build func(mailer.SecurityDetails) interface{}) error {
+	if validator.In(notification, data.OptionalNotifications...) {
+		optedOut, err := app.models.Notifications.OptedOut(user.ID, notification)
+		if err != nil {
+			return err
+		}
+
+		if optedOut {
+			return nil
+		}
+	}
+
+	userAgent := r.UserAgent()
+	if userAgent == "" {
+		userAgent = "unknown"
+	}
+
+	return app.models.Transaction(func(tx data.Models) error {
+		token, err := tx.Tokens.New(user.ID, sessionRevokeTokenTTL, data.ScopeSessionRevoke)
+		if err != nil {
+			return err
+		}
+
+		details := mailer.SecurityDetails{
+			Time:                time.Now().UTC().Format(time.RFC1123),
+			IP:                  realip.FromRequest(r),
+			UserAgent:           userAgent,
+			RevokeSessionsToken: token.Plaintext,
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, templateFile, build(details))
+	})
+}
+
+// notifyNewSignIn sends the new sign in notification if the user has signed in before, but never from the IP of the
+// request. It must be called before the sign in is recorded in the audit log.
+func (app *application) notifyNewSignIn(r *http.Request, user *data.User, method string) {
+	total, fromIP, err := app.models.Audit.CountForUser(user.ID, data.AuditLogin, realip.FromRequest(r))
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"notification": data.NotificationNewSignIn,
+			"user_id":      strconv.FormatInt(user.ID, 10),
+		})
+		return
+	}
+
+	if total == 0 || fromIP > 0 {
+		return
+	}
+
+	app.sendSecurityNotification(r, user, data.NotificationNewSignIn, "new_sign_in.html", func(details mailer.SecurityDetails) interface{} {
+		return mailer.NewSignInData{Name: user.Name, Method: method, SecurityDetails: details}
+	})
+}
+
+// notifyPasswordChanged sends the password changed notification, which users can't opt out of
+func (app *application) notifyPasswordChanged(r *http.Request, user *data.User) {
+	app.sendSecurityNotification(r, user, data.NotificationPasswordChanged, "password_changed.html", func(details mailer.SecurityDetails) interface{} {
+		return mailer.PasswordChangedData{Name: user.Name, SecurityDetails: details}
+	})
+}
+
+// notifyPermissionsGranted sends the permissions granted notification, if any permissions were granted
+func (app *application) notifyPermissionsGranted(r *http.Request, user *data.User, codes []string) {
+	if len(codes) == 0 {
+		return
+	}
+
+	app.sendSecurityNotification(r, user, data.NotificationPermissionsGranted, "permissions_granted.html", func(details mailer.SecurityDetails) interface{} {
+		return mailer.PermissionsGrantedData{Name: user.Name, Permissions: strings.Join(codes, ", "), SecurityDetails: details}
+	})
+}
+
+// notifyPermissionsAdded sends the permissions granted notification for the permissions a user has now which aren't in
+// before, e.g. those which came with a role. Failures are logged like those of the notification itself.
+func (app *application) notifyPermissionsAdded(r *http.Request, user *data.User, before data.Permissions) {
+	after, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"notification": data.NotificationPermissionsGranted,
+			"user_id":      strconv.FormatInt(user.ID, 10),
+		})
+		return
+	}
+
+	var added []string
+
+	for _, code := range after {
+		if !before.Include(code) {
+			added = append(added, code)
+		}
+	}
+
+	app.notifyPermissionsGranted(r, user, added)
+}
+
+// revokeSessionsHandler verifies the token from a security notification and signs the user out of every session,
+// including OAuth clients
+func (app *application) revokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeSessionRevoke, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeOAuthRefresh, data.ScopeMagicLink, data.ScopeSessionRevoke} {
+			err := tx.Tokens.DeleteAllForUser(scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	app.recordAuditEvent(r, data.AuditSessionsRevoked, user.ID, user.ID, nil)
+
+	env := envelope{"message": "you have been signed out of every session. We recommend resetting your password."}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notificationPreferences returns whether each optional notification is enabled, given the ones the user has opted out of
+func notificationPreferences(optOuts []string) map[string]bool {
+	preferences := make(map[string]bool, len(data.OptionalNotifications))
+
+	for _, notification := range data.OptionalNotifications {
+		preferences[notification] = !validator.In(notification, optOuts...)
+	}
+
+	return preferences
+}
+
+// showNotificationPreferencesHandler returns which of the optional security notifications the user receives
+func (app *application) showNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	optOuts, err := app.models.Notifications.GetOptOutsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notifications": notificationPreferences(optOuts)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNotificationPreferencesHandler turns optional security notifications on or off. Notifications missing from
+// the request are left as they are.
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input map[string]bool
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	for notification := range input {
+		v.Check(validator.In(notification, data.OptionalNotifications...), notification, "must be an optional notification")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var preferences map[string]bool
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		optOuts, err := tx.Notifications.GetOptOutsForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		preferences = notificationPreferences(optOuts)
+		for notification, enabled := range input {
+			preferences[notification] = enabled
+		}
+
+		optOuts = []string{}
+		for _, notification := range data.OptionalNotifications {
+			if !preferences[notification] {
+				optOuts = append(optOuts, notification)
+			}
+		}
+
+		return tx.Notifications.SetOptOuts(user.ID, optOuts)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditNotificationsUpdated, app.requestActor(r), user.ID, map[string]interface{}{"notifications": input})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notifications": preferences}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	oauthCodeTTL    = 10 * time.Minute
+	oauthAccessTTL  = time.Hour
+	oauthRefreshTTL = 30 * 24 * time.Hour
+)
+
+// consentTemplate is the page shown at /oauth/authorize, where users sign in and approve or deny a client's request
+var consentTemplate = template.Must(template.New("consent").Parse(`<!doctype html>
+<html>
+<head>
+  <meta name="viewport" content="width=device-width" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+  <title>Authorize {{.Client.Name}}</title>
+</head>
+<body>
+  <h1>{{.Client.Name}} would like to access your Greenlight account</h1>
+  <p>It is asking for permission to:</p>
+  <ul>
+    {{range .Scopes}}<li>{{.}}</li>{{end}}
+  </ul>
+  {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+  <form method="POST" action="/oauth/authorize">
+    <input type="hidden" name="response_type" value="code" />
+    <input type="hidden" name="client_id" value="{{.Client.ID}}" />
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}" />
+    <input type="hidden" name="scope" value="{{.Scope}}" />
+    <input type="hidden" name="state" value="{{.State}}" />
+    <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}" />
+    <input type="hidden" name="code_challenge_method" value="S256" />
+    <p><label>Email <input type="email" name="email" required /></label></p>
+    <p><label>Password <input type="password" name="password" /></label></p>
+    <button type="submit" name="action" value="allow">Allow</button>
+    <button type="submit" name="action" value="deny">Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+// authorizeRequest holds the parameters of an authorization request, as received by both the consent page and the form submission
+type authorizeRequest struct {
+	Client        *data.OAuthClient
+	RedirectURI   string
+	Scope         string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+	Error         string
+}
+
+// oauthErrorResponse writes an error response from the token endpoint, in the format defined by RFC 6749 section 5.2
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	if status == http.StatusUnauthorized {
+		headers.Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	env := envelope{"error": code, "error_description": description}
+
+	err := app.writeJSON(w, status, env, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// createOAuthClientHandler registers a new OAuth client owned by the current user. The client secret is only ever returned in this response.
+func (app *application) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	client := &data.OAuthClient{
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Scopes:       input.Scopes,
+		UserID:       app.contextGetUser(r).ID,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOAuthClient(v, client); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.OAuth.InsertClient(client, input.Confidential)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOAuthClientsHandler lists the OAuth clients registered by the current user
+func (app *application) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.models.OAuth.GetClientsForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOAuthClientHandler deletes an OAuth client registered by the current user, revoking every token issued to it
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	err := app.models.OAuth.DeleteClient(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateClient(id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAuthorizeRequest validates the parameters of an authorization request. Problems with the client or redirect URI are
+// returned as a validation error, as it isn't safe to redirect; any other problem is returned as an OAuth error code to redirect back with.
+func (app *application) readAuthorizeRequest(params url.Values) (*authorizeRequest, map[string]string, string, error) {
+	v := validator.New()
+
+	client, err := app.models.OAuth.GetClient(params.Get("client_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("client_id", "no matching client found")
+			return nil, v.Errors, "", nil
+		default:
+			return nil, nil, "", err
+		}
+	}
+
+	req := &authorizeRequest{
+		Client:        client,
+		RedirectURI:   params.Get("redirect_uri"),
+		Scope:         params.Get("scope"),
+		State:         params.Get("state"),
+		CodeChallenge: params.Get("code_challenge"),
+	}
+
+	if !client.HasRedirectURI(req.RedirectURI) {
+		v.AddError("redirect_uri", "must exactly match a redirect uri registered for the client")
+		return nil, v.Errors, "", nil
+	}
+
+	if params.Get("response_type") != "code" {
+		return req, nil, "unsupported_response_type", nil
+	}
+
+	// PKCE is required for every client, with the S256 method only
+	if params.Get("code_challenge_method") != "S256" || len(req.CodeChallenge) != 43 {
+		return req, nil, "invalid_request", nil
+	}
+
+	req.Scopes = strings.Fields(req.Scope)
+	if len(req.Scopes) == 0 {
+		req.Scopes = client.Scopes
+		req.Scope = strings.Join(client.Scopes, " ")
+	}
+
+	for _, scope := range req.Scopes {
+		if !validator.In(scope, client.Scopes...) {
+			return req, nil, "invalid_scope", nil
+		}
+	}
+
+	return req, nil, "", nil
+}
+
+// redirectAuthorizeResponse redirects back to the client with the given parameters and the request's state
+func (app *application) redirectAuthorizeResponse(w http.ResponseWriter, r *http.Request, req *authorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	qs := u.Query()
+	for key := range params {
+		qs.Set(key, params.Get(key))
+	}
+	u.RawQuery = qs.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// renderConsentPage writes the consent page for an authorization request
+func (app *application) renderConsentPage(w http.ResponseWriter, r *http.Request, status int, req *authorizeRequest) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// stop the page being framed, so users can't be tricked into approving a request
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+
+	w.WriteHeader(status)
+
+	err := consentTemplate.Execute(w, req)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// showAuthorizeHandler shows the consent page for an authorization request
+func (app *application) showAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	req, validationErrors, errorCode, err := app.readAuthorizeRequest(r.URL.Query())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if validationErrors != nil {
+		app.failedValidationResponse(w, r, validationErrors)
+		return
+	}
+
+	if errorCode != "" {
+		app.redirectAuthorizeResponse(w, r, req, url.Values{"error": {errorCode}})
+		return
+	}
+
+	app.renderConsentPage(w, r, http.StatusOK, req)
+}
+
+// authorizeHandler handles the consent form. When the user signs in and allows the request, an authorization code is issued
+// and the user is redirected back to the client with it.
+func (app *application) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	req, validationErrors, errorCode, err := app.readAuthorizeRequest(r.PostForm)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if validationErrors != nil {
+		app.failedValidationResponse(w, r, validationErrors)
+		return
+	}
+
+	if errorCode != "" {
+		app.redirectAuthorizeResponse(w, r, req, url.Values{"error": {errorCode}})
+		return
+	}
+
+	if r.PostForm.Get("action") != "allow" {
+		app.redirectAuthorizeResponse(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	password := r.PostForm.Get("password")
+
+	v := validator.New()
+
+	data.ValidateEmail(v, email)
+	data.ValidatePasswordPlaintext(v, password)
+
+	if !v.Valid() {
+		req.Error = "Please enter a valid email address and password."
+		app.renderConsentPage(w, r, http.StatusUnprocessableEntity, req)
+		return
+	}
+
+	user, retryAfter, err := app.checkCredentials(r, email, password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case retryAfter > 0:
+		req.Error = "Too many failed sign in attempts, please try again later."
+		app.renderConsentPage(w, r, http.StatusTooManyRequests, req)
+		return
+	case user == nil:
+		req.Error = "Invalid email address or password."
+		app.renderConsentPage(w, r, http.StatusUnauthorized, req)
+		return
+	case user.Suspended || !user.Activated:
+		req.Error = "Your account must be activated and not suspended to authorize applications."
+		app.renderConsentPage(w, r, http.StatusForbidden, req)
+		return
+	}
+
+	code := &data.OAuthCode{
+		ClientID:      req.Client.ID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+	}
+
+	err = app.models.OAuth.NewCode(code, oauthCodeTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditOAuthAuthorized, user.ID, user.ID, map[string]interface{}{
+		"client_id": req.Client.ID,
+		"scopes":    req.Scopes,
+	})
+
+	app.redirectAuthorizeResponse(w, r, req, url.Values{"code": {code.Plaintext}})
+}
+
+// authenticateClient authenticates the client making a token request, with either HTTP basic auth or the client_id and
+// client_secret form fields. Public clients only send their client_id.
+func (app *application) authenticateClient(r *http.Request) (*data.OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.models.OAuth.GetClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Confidential() && !client.SecretMatches(secret) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return client, nil
+}
+
+// createOAuthTokenHandler is the token endpoint, supporting the authorization_code, refresh_token and client_credentials grants
+func (app *application) createOAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the request body must be form encoded")
+		return
+	}
+
+	client, err := app.authenticateClient(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		app.authorizationCodeGrant(w, r, client)
+	case "refresh_token":
+		app.refreshTokenGrant(w, r, client)
+	case "client_credentials":
+		app.clientCredentialsGrant(w, r, client)
+	default:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "the grant type is not supported")
+	}
+}
+
+// authorizationCodeGrant exchanges an authorization code and its PKCE code verifier for an access and refresh token
+func (app *application) authorizationCodeGrant(w http.ResponseWriter, r *http.Request, client *data.OAuthClient) {
+	verifier := r.PostForm.Get("code_verifier")
+
+	if !data.ValidCodeVerifier(verifier) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "a valid code_verifier must be provided")
+		return
+	}
+
+	code, err := app.models.OAuth.ConsumeCode(client.ID, r.PostForm.Get("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or has expired")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if code.RedirectURI != r.PostForm.Get("redirect_uri") || !code.VerifierMatches(verifier) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or has expired")
+		return
+	}
+
+	app.issueOAuthTokens(w, r, client, code.UserID, code.Scopes, true)
+}
+
+// refreshTokenGrant exchanges a refresh token for a new access token. Refresh tokens are rotated, so each one can only be used once.
+func (app *application) refreshTokenGrant(w http.ResponseWriter, r *http.Request, client *data.OAuthClient) {
+	refreshToken, err := app.models.Tokens.Get(data.ScopeOAuthRefresh, r.PostForm.Get("refresh_token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or has expired")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if refreshToken.ClientID != client.ID {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or has expired")
+		return
+	}
+
+	// the client can ask for a subset of the scopes originally granted
+	scopes := refreshToken.OAuthScopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !validator.In(scope, refreshToken.OAuthScopes...) {
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "the scope exceeds the scope originally granted")
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	err = app.models.Tokens.Delete(refreshToken)
+	if err != nil {
+		switch {
+		// a concurrent request already used the token
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or has expired")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.issueOAuthTokens(w, r, client, refreshToken.UserID, scopes, true)
+}
+
+// clientCredentialsGrant issues an access token to a confidential client acting on its own behalf, i.e. as the user who registered it
+func (app *application) clientCredentialsGrant(w http.ResponseWriter, r *http.Request, client *data.OAuthClient) {
+	if !client.Confidential() {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unauthorized_client", "only confidential clients can use the client_credentials grant")
+		return
+	}
+
+	scopes := client.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !validator.In(scope, client.Scopes...) {
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "the scope exceeds the scope registered for the client")
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	app.issueOAuthTokens(w, r, client, client.UserID, scopes, false)
+}
+
+// issueOAuthTokens issues an access token, and optionally a refresh token, to a client acting on behalf of a user
+func (app *application) issueOAuthTokens(w http.ResponseWriter, r *http.Request, client *data.OAuthClient, userID int64, scopes []string, refresh bool) {
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the user no longer exists")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended || !user.Activated {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the user account must be activated and not suspended")
+		return
+	}
+
+	accessToken, err := app.models.Tokens.NewForClient(user.ID, oauthAccessTTL, data.ScopeAuthentication, client.ID, scopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"access_token": accessToken.Plaintext,
+		"token_type":   "Bearer",
+		"expires_in":   int(oauthAccessTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	}
+
+	if refresh {
+		refreshToken, err := app.models.Tokens.NewForClient(user.ID, oauthRefreshTTL, data.ScopeOAuthRefresh, client.ID, scopes)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env["refresh_token"] = refreshToken.Plaintext
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+)
+
+const (
+	// outboxBatchSize is the number of emails a worker claims at a time
+	outboxBatchSize = 10
+	// outboxLease is how long a claimed email is held by a worker before it is retried, so it must be longer than a send can take
+	outboxLease = 5 * time.Minute
+	// outboxBackoffBase and outboxBackoffMax bound the delay before a failed email is retried
+	outboxBackoffBase = 30 * time.Second
+	outboxBackoffMax  = time.Hour
+)
+
+// startOutboxWorkers starts the workers which send the emails in the outbox, until the server starts shutting down.
+// Once they have all stopped the mailer is closed, so mail it has queued is sent before the background tasks complete.
+func (app *application) startOutboxWorkers() {
+	app.background(func() {
+		var wg sync.WaitGroup
+
+		for i := 0; i < app.config.outbox.workers; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if err := recover(); err != nil {
+						app.logger.PrintError(fmt.Errorf("%s", err), nil)
+					}
+				}()
+
+				app.runOutboxWorker()
+			}()
+		}
+
+		wg.Wait()
+
+		// with no workers, wg.Wait returns straight away
+		<-app.shutdown
+
+		if closer, ok := app.mailer.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	})
+}
+
+// runOutboxWorker polls the outbox for emails which are due, and sends them. A full batch means there are probably more
+// waiting, so the worker carries on without waiting for the next poll.
+func (app *application) runOutboxWorker() {
+	ticker := time.NewTicker(app.config.outbox.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		claimed := app.sendOutboxBatch()
+
+		if claimed == outboxBatchSize {
+			select {
+			case <-app.shutdown:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendOutboxBatch claims and sends a batch of emails, and returns how many were claimed
+func (app *application) sendOutboxBatch() int {
+	emails, err := app.models.Outbox.Claim(outboxBatchSize, outboxLease)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return 0
+	}
+
+	for _, email := range emails {
+		app.sendOutboxEmail(email)
+	}
+
+	return len(emails)
+}
+
+// sendOutboxEmail sends an email from the outbox and records the outcome. A failed email is retried with exponential
+// backoff, until it has been attempted the maximum number of times and is moved to the dead state.
+func (app *application) sendOutboxEmail(email *data.OutboxEmail) {
+	properties := map[string]string{
+		"email_id": strconv.FormatInt(email.ID, 10),
+		"template": email.Template,
+		"attempts": strconv.Itoa(email.Attempts),
+	}
+
+	data, sendErr := mailer.DecodeData(email.Template, email.Data)
+	if sendErr == nil {
+		sendErr = app.mailer.Send(email.Recipient, email.Locale, email.Template, data)
+	}
+
+	if sendErr == nil {
+		err := app.models.Outbox.MarkSent(email)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+		return
+	}
+
+	// retrying won't help if the recipient is suppressed
+	dead := email.Attempts >= app.config.outbox.maxAttempts || errors.Is(sendErr, mailer.ErrSuppressed)
+
+	err := app.models.Outbox.MarkFailed(email, sendErr, time.Now().Add(outboxBackoff(email.Attempts)), dead)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+
+	if dead {
+		app.logger.PrintError(sendErr, properties)
+		return
+	}
+
+	app.logger.PrintInfo("email send failed, will retry: "+sendErr.Error(), properties)
+}
+
+// outboxBackoff returns the delay before an email which has failed the given number of times is retried
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBackoffBase
+
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outboxBackoffMax {
+			return outboxBackoffMax
+		}
+	}
+
+	return backoff
+}
+
+// deleteFinishedEmails deletes the emails which were sent or given up on longer ago than the outbox retention
+func (app *application) deleteFinishedEmails() error {
+	before := time.Now().Add(-app.config.outbox.retention)
+
+	total, err := app.deleteInBatches(1000, func(limit int) (int64, error) {
+		return app.models.Outbox.DeleteFinished(before, limit)
+	})
+	if err != nil {
+		return err
+	}
+
+	if total > 0 {
+		app.logger.PrintInfo("deleted finished emails", map[string]string{
+			"count": strconv.FormatInt(total, 10),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/blessedmadukoma/greenlight/internal/data"
+)
+
+// canModifyMovie checks if a user may update or delete a movie: holders of "movies:write" can change any movie, while
+// holders of "movies:write:own" can only change the movies they created
+func (app *application) canModifyMovie(user *data.User, permissions data.Permissions, movie *data.Movie) bool {
+	if permissions.Include("movies:write") {
+		return true
+	}
+
+	return permissions.Include("movies:write:own") && movie.OwnedBy(user.ID)
+}
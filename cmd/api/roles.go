@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+)
+
+// listRolesHandler lists every role along with its permissions
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createRoleHandler creates a new role with an optional set of permissions
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Description: input.Description,
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, role)
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range input.Permissions {
+		v.Check(known.Include(code), "permissions", "must only contain known permission codes")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(input.Permissions) > 0 {
+		err = app.models.Roles.AddPermissions(role.ID, input.Permissions...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	role, err = app.models.Roles.Get(role.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditRoleCreated, app.requestActor(r), 0, map[string]interface{}{
+		"role":        role.Name,
+		"permissions": role.Permissions,
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/roles/%d", role.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showRoleHandler returns a role along with its permissions
+func (app *application) showRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateRoleHandler updates the name and description of a role
+func (app *application) updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	// renaming the default role would break signups
+	if input.Name != nil && *input.Name != role.Name && role.Name == app.config.registration.defaultRole {
+		v.AddError("name", "the default role for new users cannot be renamed")
+	}
+
+	if input.Name != nil {
+		role.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+
+	if data.ValidateRole(v, role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Update(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditRoleUpdated, app.requestActor(r), 0, map[string]interface{}{
+		"role_id":     role.ID,
+		"name":        role.Name,
+		"description": role.Description,
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteRoleHandler deletes a role, removing it from every user holding it
+func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	if role.Name == app.config.registration.defaultRole {
+		app.errorResponse(w, r, http.StatusConflict, "the default role for new users cannot be deleted")
+		return
+	}
+
+	err := app.models.Roles.Delete(role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the role may have been held by any number of users
+	app.invalidateAll()
+
+	app.recordAuditEvent(r, data.AuditRoleDeleted, app.requestActor(r), 0, map[string]interface{}{"role": role.Name})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantRolePermissionsHandler adds permissions to a role
+func (app *application) grantRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.AddPermissions(role.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the role may be held by any number of users
+	app.invalidateAll()
+
+	app.recordAuditEvent(r, data.AuditRolePermissionsGranted, app.requestActor(r), 0, map[string]interface{}{
+		"role":  role.Name,
+		"codes": codes,
+	})
+
+	app.writeRole(w, r, role.ID)
+}
+
+// revokeRolePermissionsHandler removes permissions from a role
+func (app *application) revokeRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readRoleParam(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.RemovePermissions(role.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the role may be held by any number of users
+	app.invalidateAll()
+
+	app.recordAuditEvent(r, data.AuditRolePermissionsRevoked, app.requestActor(r), 0, map[string]interface{}{
+		"role":  role.Name,
+		"codes": codes,
+	})
+
+	app.writeRole(w, r, role.ID)
+}
+
+// assignUserRolesHandler assigns roles to a user
+func (app *application) assignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	names, ok := app.readRoleNames(w, r)
+	if !ok {
+		return
+	}
+
+	// the permissions held beforehand, so the user is only notified about the ones the roles add
+	before, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, names...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+
+	app.recordAuditEvent(r, data.AuditRolesAssigned, app.requestActor(r), user.ID, map[string]interface{}{"roles": names})
+
+	app.notifyPermissionsAdded(r, user, before)
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// unassignUserRolesHandler removes roles from a user
+func (app *application) unassignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	names, ok := app.readRoleNames(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Roles.RemoveForUser(user.ID, names...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+
+	app.recordAuditEvent(r, data.AuditRolesUnassigned, app.requestActor(r), user.ID, map[string]interface{}{"roles": names})
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// readRoleParam looks up the role from the id URL parameter, sending the error response and returning false if that fails
+func (app *application) readRoleParam(w http.ResponseWriter, r *http.Request) (*data.Role, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	role, err := app.models.Roles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return role, true
+}
+
+// readRoleNames reads and validates the role names in the request body, sending the error response and returning false if that fails
+func (app *application) readRoleNames(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	err = app.validateRoleNames(v, "roles", input.Roles)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.Roles, true
+}
+
+// validateRoleNames checks that a list of role names is non-empty, unique and only contains existing roles
+func (app *application) validateRoleNames(v *validator.Validator, key string, names []string) error {
+	v.Check(len(names) >= 1, key, "must contain at least 1 role")
+	v.Check(validator.Unique(names), key, "must not contain duplicate values")
+
+	for _, name := range names {
+		exists, err := app.models.Roles.Exists(name)
+		if err != nil {
+			return err
+		}
+
+		v.Check(exists, key, "must only contain existing roles")
+	}
+
+	return nil
+}
+
+// writeRole sends the current state of a role
+func (app *application) writeRole(w http.ResponseWriter, r *http.Request, id int64) {
+	role, err := app.models.Roles.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -16,20 +16,80 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requireAnyPermission([]string{"movies:write", "movies:write:own"}, app.createMovieHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requireAnyPermission([]string{"movies:write", "movies:write:own"}, app.updateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requireAnyPermission([]string{"movies:write", "movies:write:own"}, app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/owner", app.requirePermission("users:admin", app.updateMovieOwnerHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/invitations/accepted", app.acceptInvitationHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.requireScope("profile", app.showCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.requireFirstPartyToken(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireActivatedUser(app.requireFirstPartyToken(app.changeCurrentUserPasswordHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireActivatedUser(app.requireFirstPartyToken(app.requestEmailChangeHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenticatedUser(app.requireFirstPartyToken(app.deleteCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/deletion", app.requireAuthenticatedUser(app.requireFirstPartyToken(app.cancelCurrentUserDeletionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/notifications", app.requireActivatedUser(app.requireFirstPartyToken(app.showNotificationPreferencesHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/notifications", app.requireActivatedUser(app.requireFirstPartyToken(app.updateNotificationPreferencesHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/sessions/revoked", app.revokeSessionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/export", app.requireAuthenticatedUser(app.requireFirstPartyToken(app.createExportHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireAuthenticatedUser(app.requireFirstPartyToken(app.showExportHandler)))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/verify", app.verifyMagicLinkTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/clients", app.requireActivatedUser(app.requireFirstPartyToken(app.listOAuthClientsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/clients", app.requireActivatedUser(app.requireFirstPartyToken(app.createOAuthClientHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/oauth/clients/:id", app.requireActivatedUser(app.requireFirstPartyToken(app.deleteOAuthClientHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/oauth/authorize", app.showAuthorizeHandler)
+	router.HandlerFunc(http.MethodPost, "/oauth/authorize", app.authorizeHandler)
+	router.HandlerFunc(http.MethodPost, "/oauth/token", app.createOAuthTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUserStatusHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.revokeUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", app.requirePermission("users:admin", app.forceUserPasswordResetHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.assignUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.unassignUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requirePermission("users:admin", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/impersonate/:id", app.requirePermission("users:admin", app.impersonateUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit-events", app.requirePermission("users:admin", app.listAuditEventsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/invitations", app.requirePermission("users:admin", app.listInvitationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/invitations", app.requirePermission("users:admin", app.createInvitationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/invitations/:id", app.requirePermission("users:admin", app.deleteInvitationHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission("users:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles/:id", app.requirePermission("users:admin", app.showRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/roles/:id", app.requirePermission("users:admin", app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id", app.requirePermission("users:admin", app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles/:id/permissions", app.requirePermission("users:admin", app.grantRolePermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id/permissions", app.requirePermission("users:admin", app.revokeRolePermissionsHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/mail-events", app.mailEventsHandler)
 
 	// view application metrics
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// view the emails kept by the mail catcher, which is only used in development
+	if app.catcher != nil {
+		router.HandlerFunc(http.MethodGet, "/debug/mail", app.showMailCatcherHandler)
+		router.HandlerFunc(http.MethodGet, "/debug/mail/messages", app.listCaughtMailHandler)
+		router.HandlerFunc(http.MethodDelete, "/debug/mail/messages", app.deleteCaughtMailHandler)
+		router.HandlerFunc(http.MethodGet, "/debug/mail/messages/:id", app.showCaughtMailHandler)
+	}
+
+	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.auditImpersonation(router))))))
 }
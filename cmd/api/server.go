@@ -42,6 +42,9 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		// stop the periodic jobs from starting any new runs
+		close(app.shutdown)
+
 		// Log a message to say that we're waiting for any background goroutines to complete their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
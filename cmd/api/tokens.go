@@ -6,9 +6,14 @@ import (
 	"time"
 
 	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
 	"github.com/blessedmadukoma/greenlight/internal/validator"
+	"github.com/tomasen/realip"
 )
 
+// activationTokenTTL is how long the activation tokens sent on signup or resent on request last
+const activationTokenTTL = 12 * time.Hour
+
 // createAuthenticationTokenHandler creates a new authentication token for a user
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
@@ -32,40 +37,165 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, retryAfter, err := app.checkCredentials(r, input.Email, input.Password)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	match, err := user.Password.Matches(input.Password)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
 		return
 	}
 
-	if !match {
+	if user == nil {
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
 	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.notifyNewSignIn(r, user, "password")
+
+	app.recordAuditEvent(r, data.AuditLogin, user.ID, user.ID, map[string]interface{}{"method": "password"})
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// checkCredentials checks an email and password while enforcing the login lockouts. It returns a non-zero retryAfter if
+// the attempt was refused because of a lockout, or a nil user if the credentials don't match.
+func (app *application) checkCredentials(r *http.Request, email, password string) (*data.User, time.Duration, error) {
+	ip := realip.FromRequest(r)
+
+	// refuse the attempt if the account is locked, regardless of whether the password is correct
+	lockout, err := app.models.LoginAttempts.GetLockout(email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, 0, err
+	}
+
+	if lockout.Active() {
+		return nil, time.Until(lockout.LockedUntil), nil
+	}
+
+	// throttle IPs spreading failed attempts over many accounts
+	ipFailures, err := app.models.LoginAttempts.CountForIP(ip, time.Now().Add(-app.config.login.window))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if ipFailures >= app.config.login.ipMaxAttempts {
+		return nil, app.config.login.window, nil
+	}
+
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, 0, err
+	}
+
+	match := false
+
+	if user != nil {
+		match, err = user.Password.Matches(password)
+		if err != nil {
+			return nil, 0, err
+		}
+	} else {
+		data.SimulatePasswordCheck(password)
+	}
+
+	if !match {
+		return nil, 0, app.registerFailedLogin(r, email, user, lockout)
+	}
+
+	err = app.models.LoginAttempts.DeleteAllForEmail(email)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return user, 0, nil
+}
+
+// registerFailedLogin records a failed login and locks the account once the attempts in the window reach the limit.
+// user is nil when no account matches the email, in which case the email address is still locked but no notification is sent.
+func (app *application) registerFailedLogin(r *http.Request, email string, user *data.User, lockout *data.Lockout) error {
+	ip := realip.FromRequest(r)
+
+	err := app.models.LoginAttempts.Insert(email, ip)
+	if err != nil {
+		return err
+	}
+
+	var userID int64
+	if user != nil {
+		userID = user.ID
+	}
+
+	app.recordAuditEvent(r, data.AuditLoginFailed, 0, userID, map[string]interface{}{"email": email})
+
+	// only count the attempts made since the previous lockout ended
+	since := time.Now().Add(-app.config.login.window)
+	if lockout != nil && lockout.LockedUntil.After(since) {
+		since = lockout.LockedUntil
+	}
+
+	failures, err := app.models.LoginAttempts.CountForEmail(email, since)
+	if err != nil {
+		return err
+	}
+
+	if failures < app.config.login.maxAttempts {
+		return nil
+	}
+
+	if lockout == nil {
+		lockout = &data.Lockout{Email: email}
+	}
+
+	lockout.LockoutCount++
+	lockout.LockedUntil = time.Now().Add(data.LockoutDuration(lockout.LockoutCount, app.config.login.lockoutBase, app.config.login.lockoutMax))
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.LoginAttempts.Lock(lockout)
+		if err != nil || user == nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "account_locked.html", mailer.AccountLockedData{
+			Name:        user.Name,
+			IP:          ip,
+			LockedUntil: lockout.LockedUntil.UTC().Format(time.RFC1123),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	app.recordAuditEvent(r, data.AuditAccountLocked, 0, userID, map[string]interface{}{
+		"email":        email,
+		"locked_until": lockout.LockedUntil,
+	})
+
+	app.logger.PrintInfo("account locked", map[string]string{
+		"email":        email,
+		"ip":           ip,
+		"locked_until": lockout.LockedUntil.Format(time.RFC3339),
+	})
+
+	return nil
+}
+
 // createPasswordResetTokenHandler generates a password reset token and sends to the user's email
 func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
@@ -103,25 +233,229 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordRest)
+	err = app.models.Transaction(func(tx data.Models) error {
+		token, err := tx.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordRest)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "token_password_reset.html", mailer.PasswordResetData{
+			PasswordResetToken: token.Plaintext,
+		})
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.background(func() {
-		data := map[string]interface{}{
-			"passwordResetToken": token.Plaintext,
+	app.recordAuditEvent(r, data.AuditPasswordResetRequested, user.ID, user.ID, nil)
+
+	env := envelope{
+		"message": "an email will be sent to you containing password reset instructions.",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMagicLinkTokenHandler emails a one-time sign in token. The response is the same whether or not the email address
+// belongs to an account, so it can't be used to find out which addresses are registered.
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{
+		"message": "if an account exists for the email address, an email will be sent to it containing a sign in token.",
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	// a locked account can't sign in with a password either
+	lockout, err := app.models.LoginAttempts.GetLockout(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.Activated && !user.Suspended && !lockout.Active() {
+		err = app.models.Transaction(func(tx data.Models) error {
+			token, err := tx.Tokens.New(user.ID, 15*time.Minute, data.ScopeMagicLink)
+			if err != nil {
+				return err
+			}
 
-		err = app.mailer.Send(user.Email, "token_password_reset.html", data)
+			return tx.Outbox.Enqueue(user.Email, user.Locale, "token_magic_link.html", mailer.MagicLinkData{
+				Name:           user.Name,
+				MagicLinkToken: token.Plaintext,
+			})
+		})
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	})
+
+		app.recordAuditEvent(r, data.AuditMagicLinkRequested, user.ID, user.ID, nil)
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyMagicLinkTokenHandler exchanges a one-time sign in token for an authentication token
+func (app *application) verifyMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	magicLink, err := app.models.Tokens.Get(data.ScopeMagicLink, input.TokenPlaintext)
+	if err == nil {
+		// deleting the token before issuing anything means concurrent requests can't both use it
+		err = app.models.Tokens.Delete(magicLink)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired sign in token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(magicLink.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	// any other sign in links sent to the user are no longer needed
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.notifyNewSignIn(r, user, "magic link")
+
+	app.recordAuditEvent(r, data.AuditLogin, user.ID, user.ID, map[string]interface{}{"method": "magic_link"})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler resends an activation token to an unactivated account. The response is the same whatever
+// the state of the account, and at most one token is sent per cooldown period so the endpoint can't be used to flood an inbox.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	env := envelope{
-		"message": "an email will be sent to you containing password reset instructions.",
+		"message": "if an unactivated account exists for the email address, an email will be sent to it containing activation instructions.",
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		// work out when the newest activation token was issued from its expiry
+		expiry, err := app.models.Tokens.LatestExpiryForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if expiry.IsZero() || time.Since(expiry.Add(-activationTokenTTL)) >= app.config.activation.resendCooldown {
+			err = app.sendActivationToken(user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			app.recordAuditEvent(r, data.AuditActivationResent, user.ID, user.ID, nil)
+		}
 	}
 
 	err = app.writeJSON(w, http.StatusAccepted, env, nil)
@@ -129,3 +463,23 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// sendActivationToken replaces the user's activation tokens with a new one and emails it to them
+func (app *application) sendActivationToken(user *data.User) error {
+	return app.models.Transaction(func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(user.ID, activationTokenTTL, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "token_activation.html", mailer.ActivationData{
+			Name:            user.Name,
+			ActivationToken: token.Plaintext,
+		})
+	})
+}
@@ -6,10 +6,16 @@ import (
 	"time"
 
 	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
 	"github.com/blessedmadukoma/greenlight/internal/validator"
 )
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.registration.mode != registrationOpen {
+		app.registrationClosedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
@@ -26,6 +32,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Locale:    mailer.MatchLocale(r.Header.Get("Accept-Language")),
 	}
 
 	err = user.Password.Set(input.Password)
@@ -41,7 +48,33 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// the user, their activation token and the welcome email are written together, so the email can't be lost
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		// assign the configured default role to the user
+		if app.config.registration.defaultRole != "" {
+			err = tx.Roles.AddForUser(user.ID, app.config.registration.defaultRole)
+			if err != nil {
+				return err
+			}
+		}
+
+		// generate token
+		token, err := tx.Tokens.New(user.ID, activationTokenTTL, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "user_welcome.html", mailer.WelcomeData{
+			ActivationToken: token.Plaintext,
+			UserID:          user.ID,
+			Name:            user.Name,
+		})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -54,38 +87,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// add "movie:read" permission to the user
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// generate token
-	token, err := app.models.Tokens.New(user.ID, 12*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// send email -> handle panic in background method
-	app.background(func() {
-		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-			"Name":            user.Name,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.html", data)
-		if err != nil {
-			// using app.serverErrorResponse gives error of "http: superfluous response.WriteHeader call" due to us trying to write a second HTTP response.
-			// app.serverErrorResponse(w, r, err)
-			// return
-
-			// if there is an error, log it out
-			app.logger.PrintError(err, nil)
-		}
-	})
+	app.recordAuditEvent(r, data.AuditUserRegistered, user.ID, user.ID, nil)
 
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
@@ -139,6 +141,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	app.invalidateUser(user.ID)
+
 	// delete all activation tokens for the user
 	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
 	if err != nil {
@@ -146,6 +150,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	app.recordAuditEvent(r, data.AuditUserActivated, user.ID, user.ID, nil)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -206,12 +212,25 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	app.invalidateUser(user.ID)
+
+	// OAuth clients must be re-authorized after a password change
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeOAuthRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordRest, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.recordAuditEvent(r, data.AuditPasswordReset, user.ID, user.ID, nil)
+
+	app.notifyPasswordChanged(r, user)
+
 	env := envelope{"message": "your password was successfully reset"}
 
 	err = app.writeJSON(w, http.StatusOK, env, nil)
@@ -219,3 +238,455 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// showCurrentUserHandler returns the profile, roles and permissions of the authenticated user
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// an OAuth client only sees the permissions covered by its scopes, the same ones requireAnyPermission lets it use
+	if token := app.contextGetToken(r); token.IsOAuth() {
+		permissions = permissions.Intersect(data.PermissionsForScopes(token.OAuthScopes))
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCurrentUserHandler updates the profile of the authenticated user
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Name   *string `json:"name"`
+		Locale *string `json:"locale"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Locale != nil {
+		user.Locale = *input.Locale
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(user.Locale, mailer.Locales()...), "locale", "must be a supported locale")
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// changeCurrentUserPasswordHandler sets a new password for the authenticated user after verifying the current one, and revokes all their other sessions
+func (app *application) changeCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.NewPassword)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// keep the session used for this request, but sign the user out everywhere else
+	err = app.models.Tokens.DeleteAllForUserExcept(data.ScopeAuthentication, user.ID, app.contextGetToken(r).Plaintext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	// OAuth clients must be re-authorized after a password change
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeOAuthRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordRest, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditPasswordChanged, app.requestActor(r), user.ID, nil)
+
+	app.notifyPasswordChanged(r, user)
+
+	env := envelope{"message": "your password was successfully changed"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requestEmailChangeHandler stores a pending email address for the authenticated user and mails a confirmation token to it
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Email != user.Email, "email", "must be different from the current email address")
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.PendingEmail = input.Email
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		// only the most recently requested address can be confirmed
+		err = tx.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Outbox.Enqueue(user.PendingEmail, user.Locale, "token_email_change.html", mailer.EmailChangeData{
+			Name:             user.Name,
+			NewEmail:         user.PendingEmail,
+			EmailChangeToken: token.Plaintext,
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "email_change_notice.html", mailer.EmailChangeNoticeData{
+			Name:     user.Name,
+			NewEmail: user.PendingEmail,
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	app.recordAuditEvent(r, data.AuditEmailChangeRequested, app.requestActor(r), user.ID, map[string]interface{}{"new_email": user.PendingEmail})
+
+	env := envelope{
+		"message": "an email will be sent to the new address containing confirmation instructions.",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler applies a pending email address once its confirmation token is verified
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.PendingEmail == "" {
+		v.AddError("token", "invalid or expired email change token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	// the new address hasn't bounced, so the user no longer needs to be asked for one
+	user.EmailBounced = false
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAuditEvent(r, data.AuditEmailChanged, user.ID, user.ID, map[string]interface{}{"email": user.Email})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentUserHandler schedules the authenticated user's account for deletion once the grace period ends
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deletionScheduledAt := time.Now().Add(app.config.deletion.gracePeriod)
+	user.DeletionScheduledAt = &deletionScheduledAt
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Outbox.Enqueue(user.Email, user.Locale, "account_deletion_scheduled.html", mailer.AccountDeletionScheduledData{
+			Name:              user.Name,
+			DeletionScheduled: deletionScheduledAt.UTC().Format(time.RFC1123),
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	app.recordAuditEvent(r, data.AuditDeletionScheduled, app.requestActor(r), user.ID, map[string]interface{}{"deletion_scheduled_at": user.DeletionScheduledAt})
+
+	env := envelope{
+		"message": "your account is scheduled for deletion, an email will be sent to you with instructions to cancel it.",
+		"user":    user,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelCurrentUserDeletionHandler cancels a scheduled deletion of the authenticated user's account
+func (app *application) cancelCurrentUserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if user.DeletionScheduledAt == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user.DeletionScheduledAt = nil
+
+	err := app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateUser(user.ID)
+
+	app.recordAuditEvent(r, data.AuditDeletionCancelled, app.requestActor(r), user.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/data"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+)
+
+// mailEventsTolerance is how far the signature timestamp of a mail events request can be from the current time
+const mailEventsTolerance = 5 * time.Minute
+
+// mailEventsHandler receives signed bounce and complaint notifications. Hard bounces and complaints add the recipient
+// to the suppression list, and a hard bounce also flags the user with the address so they can be asked for a new one.
+// Soft bounces are temporary, so the outbox retries are left to deal with them.
+func (app *application) mailEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// without a secret anyone could suppress any address, so the webhook is disabled
+	if app.config.webhooks.mailSecret == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = mailer.VerifyEvents(app.config.webhooks.mailSecret, r.Header.Get(mailer.SignatureHeader), body, time.Now(), mailEventsTolerance)
+	if err != nil {
+		app.invalidSignatureResponse(w, r)
+		return
+	}
+
+	var input mailer.Events
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	for i, event := range input.Events {
+		key := "events[" + strconv.Itoa(i) + "]"
+
+		v.Check(validator.Matches(event.Recipient, validator.EmailRX), key+".recipient", "must be a valid email address")
+		v.Check(validator.In(event.Type, mailer.EventBounce, mailer.EventComplaint), key+".type", "must be bounce or complaint")
+
+		if event.Type == mailer.EventBounce {
+			v.Check(validator.In(event.BounceType, mailer.BounceHard, mailer.BounceSoft), key+".bounce_type", "must be hard or soft")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suppressed := 0
+
+	for _, event := range input.Events {
+		reason := data.SuppressionComplaint
+		if event.Type == mailer.EventBounce {
+			if event.BounceType == mailer.BounceSoft {
+				continue
+			}
+			reason = data.SuppressionHardBounce
+		}
+
+		err := app.suppressEmail(r, event.Recipient, reason, event.Diagnostic)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		suppressed++
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"received": len(input.Events), "suppressed": suppressed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// suppressEmail adds an address to the suppression list, flagging the user it belongs to if it hard bounced
+func (app *application) suppressEmail(r *http.Request, email, reason, details string) error {
+	var userID int64
+
+	err := app.models.Transaction(func(tx data.Models) error {
+		err := tx.Suppressions.Insert(&data.Suppression{Email: email, Reason: reason, Details: details})
+		if err != nil {
+			return err
+		}
+
+		if reason != data.SuppressionHardBounce {
+			return nil
+		}
+
+		userID, err = tx.Users.FlagBounced(email)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if userID != 0 {
+		app.invalidateUser(userID)
+	}
+
+	app.recordAuditEvent(r, data.AuditEmailSuppressed, 0, userID, map[string]interface{}{"email": email, "reason": reason})
+
+	return nil
+}
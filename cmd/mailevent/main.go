@@ -0,0 +1,117 @@
+// Command mailevent stands in for a mail provider during local development. It signs a bounce or complaint
+// notification with the webhook secret and posts it to the API's mail events webhook, or prints the signed request.
+//
+//	go run ./cmd/mailevent -recipient=alice@example.com
+//	go run ./cmd/mailevent -recipient=alice@example.com -type=complaint
+//	go run ./cmd/mailevent -recipient=alice@example.com -bounce-type=soft -print
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/jsonlog"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+type config struct {
+	url        string
+	secret     string
+	eventType  string
+	bounceType string
+	recipient  string
+	diagnostic string
+	print      bool
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.url, "url", "http://localhost:4000/v1/webhooks/mail-events", "URL of the mail events webhook")
+	flag.StringVar(&cfg.secret, "secret", os.Getenv("MAIL_WEBHOOK_SECRET"), "Shared secret the request is signed with")
+	flag.StringVar(&cfg.eventType, "type", mailer.EventBounce, "Event type (bounce|complaint)")
+	flag.StringVar(&cfg.bounceType, "bounce-type", mailer.BounceHard, "Bounce type (hard|soft)")
+	flag.StringVar(&cfg.recipient, "recipient", "", "Email address the event is for")
+	flag.StringVar(&cfg.diagnostic, "diagnostic", "550 5.1.1 The email account that you tried to reach does not exist", "Diagnostic message, e.g. the SMTP response")
+	flag.BoolVar(&cfg.print, "print", false, "Print the signed request instead of sending it")
+
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	if cfg.recipient == "" || cfg.secret == "" {
+		logger.PrintFatal(fmt.Errorf("-recipient and -secret (or MAIL_WEBHOOK_SECRET) must be provided"), nil)
+	}
+
+	if !validator.In(cfg.eventType, mailer.EventBounce, mailer.EventComplaint) {
+		logger.PrintFatal(fmt.Errorf("invalid event type %q", cfg.eventType), nil)
+	}
+
+	event := mailer.Event{
+		Type:       cfg.eventType,
+		Recipient:  cfg.recipient,
+		OccurredAt: time.Now().UTC().Truncate(time.Second),
+		Diagnostic: cfg.diagnostic,
+	}
+
+	if cfg.eventType == mailer.EventBounce {
+		event.BounceType = cfg.bounceType
+	}
+
+	body, err := json.Marshal(mailer.Events{Events: []mailer.Event{event}})
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	signature := mailer.SignEvents(cfg.secret, body, time.Now())
+
+	if cfg.print {
+		fmt.Printf("%s: %s\n\n%s\n", mailer.SignatureHeader, signature, body)
+		return
+	}
+
+	err = send(cfg.url, signature, body)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+}
+
+// send posts the signed events to the webhook and prints the response
+func send(url, signature string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(mailer.SignatureHeader, signature)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n%s", res.Status, resBody)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+// Command mailpreview renders the email templates with sample or JSON-provided data, so they can be checked without
+// triggering the emails through the API. It writes the rendered emails to disk, serves them for live preview, or
+// sends one through the configured SMTP server.
+//
+//	go run ./cmd/mailpreview -template=user_welcome.html -locale=fr
+//	go run ./cmd/mailpreview -serve=localhost:4001 -templates-dir=./internal/mailer/templates
+//	go run ./cmd/mailpreview -template=user_welcome.html -data=welcome.json -send=me@example.com
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/blessedmadukoma/greenlight/internal/jsonlog"
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+type config struct {
+	template     string
+	locale       string
+	data         string
+	sampleData   bool
+	templatesDir string
+	out          string
+	serve        string
+	send         string
+	smtp         struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	dkim struct {
+		domain   string
+		selector string
+		keyFile  string
+	}
+}
+
+type application struct {
+	config config
+	logger *jsonlog.Logger
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.template, "template", "", "Template to render, e.g. user_welcome.html (all templates if empty)")
+	flag.StringVar(&cfg.locale, "locale", mailer.DefaultLocale, "Locale to render the template in")
+	flag.StringVar(&cfg.data, "data", "", "JSON file with the template data (sample data if empty)")
+	flag.BoolVar(&cfg.sampleData, "sample-data", false, "Print the sample data for -template as JSON, as a starting point for -data, and exit")
+	flag.StringVar(&cfg.templatesDir, "templates-dir", "", "Directory to read the templates from on every render (embedded templates if empty)")
+	flag.StringVar(&cfg.out, "out", "./tmp/mailpreview", "Directory the rendered emails are written to")
+	flag.StringVar(&cfg.serve, "serve", "", "Address to serve a live preview of the templates on, e.g. localhost:4001")
+	flag.StringVar(&cfg.send, "send", "", "Email address to send the rendered -template to through the SMTP server")
+
+	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", smtpPort, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", os.Getenv("SMTP_EMAIL_ADDRESS"), "SMTP sender")
+
+	flag.StringVar(&cfg.dkim.domain, "dkim-domain", "", "DKIM signing domain for -send")
+	flag.StringVar(&cfg.dkim.selector, "dkim-selector", "", "DKIM selector for -send")
+	flag.StringVar(&cfg.dkim.keyFile, "dkim-private-key", "", "PEM file with the DKIM private key for -send (signing is disabled if empty)")
+
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	if cfg.template != "" && !validator.In(cfg.template, mailer.Templates()...) {
+		logger.PrintFatal(fmt.Errorf("unknown template %q, must be one of %s", cfg.template, strings.Join(mailer.Templates(), ", ")), nil)
+	}
+
+	if cfg.template == "" && (cfg.data != "" || cfg.sampleData || cfg.send != "") {
+		logger.PrintFatal(fmt.Errorf("-data, -sample-data and -send need a -template"), nil)
+	}
+
+	app := &application{
+		config: cfg,
+		logger: logger,
+	}
+
+	var err error
+
+	switch {
+	case cfg.sampleData:
+		var js []byte
+
+		js, err = sampleJSON(cfg.template)
+		if err == nil {
+			fmt.Println(string(js))
+		}
+	case cfg.serve != "":
+		err = app.serve()
+	case cfg.send != "":
+		err = app.sendTemplate()
+	default:
+		err = app.writeTemplates()
+	}
+
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+}
+
+// render builds the email for a template with the data from -data or the sample data
+func (app *application) render(templateFile, locale, recipient string) (*mailer.Message, error) {
+	data, err := templateData(templateFile, app.config.data)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.config.templatesDir != "" {
+		return mailer.RenderDir(app.config.templatesDir, app.config.smtp.sender, recipient, locale, templateFile, data)
+	}
+
+	return mailer.Render(app.config.smtp.sender, recipient, locale, templateFile, data)
+}
+
+// templates returns the templates chosen with -template
+func (app *application) templates() []string {
+	if app.config.template != "" {
+		return []string{app.config.template}
+	}
+
+	return mailer.Templates()
+}
+
+// writeTemplates writes the subject, plain body and HTML body of each chosen template to the output directory
+func (app *application) writeTemplates() error {
+	err := os.MkdirAll(app.config.out, 0o755)
+	if err != nil {
+		return err
+	}
+
+	for _, templateFile := range app.templates() {
+		msg, err := app.render(templateFile, app.config.locale, "preview@example.com")
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(templateFile, ".html")
+		if app.config.locale != mailer.DefaultLocale {
+			name += "." + app.config.locale
+		}
+
+		files := map[string]string{
+			name + ".subject.txt": msg.Subject,
+			name + ".txt":         msg.PlainBody,
+			name + ".html":        msg.HTMLBody,
+		}
+
+		for file, contents := range files {
+			err = os.WriteFile(filepath.Join(app.config.out, file), []byte(contents), 0o644)
+			if err != nil {
+				return err
+			}
+		}
+
+		app.logger.PrintInfo("rendered template", map[string]string{
+			"template": templateFile,
+			"locale":   app.config.locale,
+			"subject":  msg.Subject,
+			"dir":      app.config.out,
+		})
+	}
+
+	return nil
+}
+
+// sendTemplate sends the chosen template to the -send address through the SMTP server
+func (app *application) sendTemplate() error {
+	msg, err := app.render(app.config.template, app.config.locale, app.config.send)
+	if err != nil {
+		return err
+	}
+
+	smtp := mailer.NewSMTP(app.config.smtp.host, app.config.smtp.port, app.config.smtp.username, app.config.smtp.password, app.config.smtp.sender)
+
+	if app.config.dkim.keyFile != "" {
+		signer, err := mailer.LoadDKIMSigner(app.config.dkim.domain, app.config.dkim.selector, app.config.dkim.keyFile)
+		if err != nil {
+			return err
+		}
+
+		smtp.SignWith(signer)
+	}
+
+	err = smtp.SendMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("sent template", map[string]string{
+		"template": app.config.template,
+		"locale":   app.config.locale,
+		"to":       app.config.send,
+	})
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+)
+
+// sampleToken looks like a real token, so that the emails wrap the way they will when they're sent
+const sampleToken = "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+
+// sampleTime is used for the expiry and lockout times in the sample data
+var sampleTime = time.Date(2030, time.January, 1, 12, 0, 0, 0, time.UTC).Format(time.RFC1123)
+
+// sampleSecurityDetails is shared by the security notification samples
+var sampleSecurityDetails = mailer.SecurityDetails{
+	Time:                sampleTime,
+	IP:                  "203.0.113.7",
+	UserAgent:           "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15",
+	RevokeSessionsToken: sampleToken,
+}
+
+// samples holds realistic data for each template, used unless data is given with -data
+var samples = map[string]interface{}{
+	"user_welcome.html": mailer.WelcomeData{
+		Name:            "Alice Smith",
+		UserID:          42,
+		ActivationToken: sampleToken,
+	},
+	"token_activation.html": mailer.ActivationData{
+		Name:            "Alice Smith",
+		ActivationToken: sampleToken,
+	},
+	"token_password_reset.html": mailer.PasswordResetData{
+		PasswordResetToken: sampleToken,
+	},
+	"admin_password_reset.html": mailer.AdminPasswordResetData{
+		Name:               "Alice Smith",
+		PasswordResetToken: sampleToken,
+	},
+	"token_magic_link.html": mailer.MagicLinkData{
+		Name:           "Alice Smith",
+		MagicLinkToken: sampleToken,
+	},
+	"token_email_change.html": mailer.EmailChangeData{
+		Name:             "Alice Smith",
+		NewEmail:         "alice@example.net",
+		EmailChangeToken: sampleToken,
+	},
+	"email_change_notice.html": mailer.EmailChangeNoticeData{
+		Name:     "Alice Smith",
+		NewEmail: "alice@example.net",
+	},
+	"token_data_export.html": mailer.DataExportData{
+		Name:            "Alice Smith",
+		DataExportToken: sampleToken,
+	},
+	"account_locked.html": mailer.AccountLockedData{
+		Name:        "Alice Smith",
+		IP:          "203.0.113.7",
+		LockedUntil: sampleTime,
+	},
+	"account_deletion_scheduled.html": mailer.AccountDeletionScheduledData{
+		Name:              "Alice Smith",
+		DeletionScheduled: sampleTime,
+	},
+	"invitation.html": mailer.InvitationData{
+		InviterName:     "Bob Jones",
+		InvitationToken: sampleToken,
+		Expiry:          sampleTime,
+	},
+	"password_changed.html": mailer.PasswordChangedData{
+		Name:            "Alice Smith",
+		SecurityDetails: sampleSecurityDetails,
+	},
+	"new_sign_in.html": mailer.NewSignInData{
+		Name:            "Alice Smith",
+		Method:          "password",
+		SecurityDetails: sampleSecurityDetails,
+	},
+	"permissions_granted.html": mailer.PermissionsGrantedData{
+		Name:            "Alice Smith",
+		Permissions:     "movies:read, movies:write",
+		SecurityDetails: sampleSecurityDetails,
+	},
+}
+
+// templateData returns the data to render a template with, read from the JSON file at path if it's set.
+// Fields missing from the file are left empty, and templates without sample data are rendered with empty data.
+func templateData(templateFile, path string) (interface{}, error) {
+	if path != "" {
+		js, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return mailer.DecodeData(templateFile, js)
+	}
+
+	if data, ok := samples[templateFile]; ok {
+		return data, nil
+	}
+
+	return mailer.DecodeData(templateFile, []byte("{}"))
+}
+
+// sampleJSON returns the sample data for a template as JSON, as a starting point for a -data file
+func sampleJSON(templateFile string) ([]byte, error) {
+	data, err := templateData(templateFile, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(data, "", "\t")
+}
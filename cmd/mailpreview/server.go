@@ -0,0 +1,99 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/mailer"
+)
+
+// indexTemplate links to the preview of each template in each locale
+var indexTemplate = template.Must(template.New("index").Parse(`<!doctype html>
+<html>
+<head>
+ <meta charset="utf-8">
+ <title>Greenlight email previews</title>
+</head>
+<body>
+ <h1>Greenlight email previews</h1>
+ <table>
+  {{range .Templates}}
+  {{$template := .}}
+  <tr>
+   <td><code>{{$template}}</code></td>
+   {{range $.Locales}}
+   <td>
+    <a href="/preview?template={{$template}}&amp;locale={{.}}">{{.}}</a>
+    (<a href="/preview?template={{$template}}&amp;locale={{.}}&amp;format=plain">plain</a>)
+   </td>
+   {{end}}
+  </tr>
+  {{end}}
+ </table>
+</body>
+</html>
+`))
+
+// serve serves an index of the templates and a preview of each one. Templates are rendered on every request, so with
+// -templates-dir an edit shows up on the next reload.
+func (app *application) serve() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", app.indexHandler)
+	mux.HandleFunc("/preview", app.previewHandler)
+
+	srv := &http.Server{
+		Addr:         app.config.serve,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	app.logger.PrintInfo("serving email previews", map[string]string{"addr": "http://" + app.config.serve})
+
+	return srv.ListenAndServe()
+}
+
+// indexHandler lists the templates
+func (app *application) indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := indexTemplate.Execute(w, map[string]interface{}{
+		"Templates": app.templates(),
+		"Locales":   mailer.Locales(),
+	})
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// previewHandler renders a template, as HTML or as its subject and plain body with format=plain
+func (app *application) previewHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	locale := qs.Get("locale")
+	if locale == "" {
+		locale = app.config.locale
+	}
+
+	msg, err := app.render(qs.Get("template"), locale, "preview@example.com")
+	if err != nil {
+		// a template being edited often doesn't parse, so show why instead of a generic error
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if qs.Get("format") == "plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Subject: " + msg.Subject + "\n" + msg.PlainBody))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(msg.HTMLBody))
+}
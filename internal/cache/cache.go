@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the counters of a cache
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// Cache is an in-memory key/value cache safe for concurrent use. Entries expire after the TTL, and once the cache holds
+// maxEntries the least recently used entry is evicted to make room for a new one.
+type Cache[K comparable, V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	ll         *list.List
+	items      map[K]*list.Element
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// New creates a new Cache. A ttl or maxEntries of zero or less disables the cache, so every lookup misses.
+func New[K comparable, V any](ttl time.Duration, maxEntries int) *Cache[K, V] {
+	return &Cache[K, V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[K]*list.Element),
+	}
+}
+
+// enabled checks if the cache stores anything at all
+func (c *Cache[K, V]) enabled() bool {
+	return c.ttl > 0 && c.maxEntries > 0
+}
+
+// Get returns the value stored for a key, and whether it was found and hasn't expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+
+	el, found := c.items[key]
+	if !found {
+		c.misses.Add(1)
+		return zero, false
+	}
+
+	e := el.Value.(*entry[K, V])
+
+	if time.Now().After(e.expires) {
+		c.removeElement(el)
+		c.misses.Add(1)
+		return zero, false
+	}
+
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+
+	return e.value, true
+}
+
+// Set stores a value for a key, replacing any existing value and resetting its TTL
+func (c *Cache[K, V]) Set(key K, value V) {
+	if !c.enabled() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+
+	if el, found := c.items[key]; found {
+		e := el.Value.(*entry[K, V])
+		e.value = value
+		e.expires = expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+
+	for c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Delete removes the value stored for a key
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.items[key]; found {
+		c.removeElement(el)
+	}
+}
+
+// DeleteFunc removes every entry for which fn returns true
+func (c *Cache[K, V]) DeleteFunc(fn func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+
+		e := el.Value.(*entry[K, V])
+		if fn(e.key, e.value) {
+			c.removeElement(el)
+		}
+
+		el = next
+	}
+}
+
+// Purge removes every entry
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[K]*list.Element)
+}
+
+// Stats returns the current counters of the cache
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	entries := c.ll.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+// removeElement removes an entry from both the list and the map. The caller must hold the lock.
+func (c *Cache[K, V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
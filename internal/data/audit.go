@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+)
+
+// the actions recorded in the audit log
+const (
+	AuditLogin                  = "login"
+	AuditLoginFailed            = "login_failed"
+	AuditAccountLocked          = "account_locked"
+	AuditAccountUnlocked        = "account_unlocked"
+	AuditMagicLinkRequested     = "magic_link_requested"
+	AuditUserRegistered         = "user_registered"
+	AuditUserActivated          = "user_activated"
+	AuditActivationResent       = "activation_resent"
+	AuditPasswordResetRequested = "password_reset_requested"
+	AuditPasswordReset          = "password_reset"
+	AuditPasswordChanged        = "password_changed"
+	AuditEmailChangeRequested   = "email_change_requested"
+	AuditEmailChanged           = "email_changed"
+	AuditDeletionScheduled      = "deletion_scheduled"
+	AuditDeletionCancelled      = "deletion_cancelled"
+	AuditUserStatusChanged      = "user_status_changed"
+	AuditPermissionsGranted     = "permissions_granted"
+	AuditPermissionsRevoked     = "permissions_revoked"
+	AuditRolesAssigned          = "roles_assigned"
+	AuditRolesUnassigned        = "roles_unassigned"
+	AuditRolePermissionsGranted = "role_permissions_granted"
+	AuditRolePermissionsRevoked = "role_permissions_revoked"
+	AuditRoleCreated            = "role_created"
+	AuditRoleUpdated            = "role_updated"
+	AuditRoleDeleted            = "role_deleted"
+	AuditForcedPasswordReset    = "forced_password_reset"
+	AuditInvitationAccepted     = "invitation_accepted"
+	AuditOAuthAuthorized        = "oauth_authorized"
+	AuditImpersonationStarted   = "impersonation_started"
+	AuditImpersonatedRequest    = "impersonated_request"
+	AuditSessionsRevoked        = "sessions_revoked"
+	AuditNotificationsUpdated   = "notifications_updated"
+	AuditEmailSuppressed        = "email_suppressed"
+)
+
+// AuditEvent records an action taken on the API. ActorID is who really performed it and UserID is the account it was
+// performed as or on, which differ when an admin is impersonating a user or managing their account.
+type AuditEvent struct {
+	ID        int64                  `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Action    string                 `json:"action"`
+	ActorID   int64                  `json:"actor_id,omitempty"` // zero for anonymous actions, or once the user has been deleted
+	UserID    int64                  `json:"user_id,omitempty"`  // zero if no account was involved, or once the user has been deleted
+	IP        string                 `json:"ip"`
+	UserAgent string                 `json:"user_agent"`
+	Details   map[string]interface{} `json:"details"`
+}
+
+// AuditFilters holds the filters and cursor for querying the audit log. Events are returned newest first,
+// and Cursor is the ID of the last event of the previous page.
+type AuditFilters struct {
+	Action   string
+	ActorID  int64
+	UserID   int64
+	Since    time.Time
+	Until    time.Time
+	Cursor   int64
+	PageSize int
+}
+
+func ValidateAuditFilters(v *validator.Validator, f AuditFilters) {
+	v.Check(f.ActorID >= 0, "actor_id", "must not be negative")
+	v.Check(f.UserID >= 0, "user_id", "must not be negative")
+	v.Check(f.Cursor >= 0, "cursor", "must not be negative")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(f.Since.IsZero() || f.Until.IsZero() || f.Since.Before(f.Until), "since", "must be before until")
+}
+
+// AuditModel struct and methods for interacting with the audit log in the DB
+type AuditModel struct {
+	DB DBTX
+}
+
+// Insert writes an event to the audit log
+func (m AuditModel) Insert(event *AuditEvent) error {
+	if event.Details == nil {
+		event.Details = map[string]interface{}{}
+	}
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO audit_events (action, actor_id, user_id, ip, user_agent, details)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	args := []interface{}{
+		event.Action,
+		sql.NullInt64{Int64: event.ActorID, Valid: event.ActorID != 0},
+		sql.NullInt64{Int64: event.UserID, Valid: event.UserID != 0},
+		event.IP,
+		event.UserAgent,
+		details,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAll returns a page of the events matching the filters, newest first, along with the cursor for the next page (zero on the last page)
+func (m AuditModel) GetAll(filters AuditFilters) ([]*AuditEvent, int64, error) {
+	query := `
+	SELECT id, created_at, action, COALESCE(actor_id, 0), COALESCE(user_id, 0), ip, user_agent, details
+	FROM audit_events
+	WHERE (action = $1 OR $1 = '')
+	AND (actor_id = $2 OR $2 = 0)
+	AND (user_id = $3 OR $3 = 0)
+	AND ($4::timestamptz IS NULL OR created_at >= $4)
+	AND ($5::timestamptz IS NULL OR created_at < $5)
+	AND (id < $6 OR $6 = 0)
+	ORDER BY id DESC
+	LIMIT $7`
+
+	args := []interface{}{
+		filters.Action,
+		filters.ActorID,
+		filters.UserID,
+		sql.NullTime{Time: filters.Since, Valid: !filters.Since.IsZero()},
+		sql.NullTime{Time: filters.Until, Valid: !filters.Until.IsZero()},
+		filters.Cursor,
+		filters.PageSize + 1, // fetch one extra event to tell if there's another page
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var (
+			event   AuditEvent
+			details []byte
+		)
+
+		err = rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Action,
+			&event.ActorID,
+			&event.UserID,
+			&event.IP,
+			&event.UserAgent,
+			&details,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var nextCursor int64
+
+	if len(events) > filters.PageSize {
+		events = events[:filters.PageSize]
+		nextCursor = events[len(events)-1].ID
+	}
+
+	return events, nextCursor, nil
+}
+
+// CountForUser returns how many events with the given action have been recorded for a user, and how many of those came from ip
+func (m AuditModel) CountForUser(userID int64, action, ip string) (int, int, error) {
+	query := `
+	SELECT count(*), count(*) FILTER (WHERE ip = $3)
+	FROM audit_events
+	WHERE user_id = $1 AND action = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var total, fromIP int
+
+	err := m.DB.QueryRowContext(ctx, query, userID, action, ip).Scan(&total, &fromIP)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return total, fromIP, nil
+}
+
+// DeleteBefore deletes up to limit events recorded before the given time, and returns how many were deleted
+func (m AuditModel) DeleteBefore(before time.Time, limit int) (int64, error) {
+	query := `
+	DELETE FROM audit_events
+	WHERE id IN (
+		SELECT id FROM audit_events
+		WHERE created_at < $1
+		LIMIT $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
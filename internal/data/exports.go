@@ -0,0 +1,70 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const ScopeDataExport = "data-export"
+
+// Export holds a JSON archive of all the data tied to a user
+type Export struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"-"`
+	CreatedAt time.Time       `json:"created_at"`
+	Archive   json.RawMessage `json:"archive"`
+}
+
+// ExportModel struct and methods for interacting with the DB
+type ExportModel struct {
+	DB DBTX
+}
+
+// Insert stores a new export archive for a user
+func (m ExportModel) Insert(export *Export) error {
+	query := `
+	INSERT INTO user_exports (user_id, archive)
+	VALUES ($1, $2)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, export.UserID, []byte(export.Archive)).Scan(&export.ID, &export.CreatedAt)
+}
+
+// GetLatestForUser retrieves the most recent export archive for a user
+func (m ExportModel) GetLatestForUser(userID int64) (*Export, error) {
+	query := `
+	SELECT id, user_id, created_at, archive
+	FROM user_exports
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	LIMIT 1`
+
+	var export Export
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.CreatedAt,
+		&export.Archive,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
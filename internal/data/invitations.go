@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Invitation lets someone register an account while registration isn't open, with roles and permissions chosen by an admin
+type Invitation struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Email       string     `json:"email"`
+	Plaintext   string     `json:"-"` // only sent to the invitee by email
+	Hash        []byte     `json:"-"`
+	Roles       []string   `json:"roles"`
+	Permissions []string   `json:"permissions"`
+	Expiry      time.Time  `json:"expiry"`
+	InvitedBy   int64      `json:"invited_by,omitempty"` // zero if the inviting admin has since been deleted
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty"`
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(validator.Unique(invitation.Roles), "roles", "must not contain duplicate values")
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+}
+
+// InvitationModel struct and methods for interacting with the invitations in the DB
+type InvitationModel struct {
+	DB DBTX
+}
+
+// Insert generates a token for a new invitation and inserts it into the database
+func (m InvitationModel) Insert(invitation *Invitation, ttl time.Duration) error {
+	token, err := generateToken(0, ttl, "")
+	if err != nil {
+		return err
+	}
+
+	invitation.Plaintext = token.Plaintext
+	invitation.Hash = token.Hash
+	invitation.Expiry = token.Expiry
+
+	query := `
+	INSERT INTO invitations (email, hash, roles, permissions, expiry, invited_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	args := []interface{}{
+		invitation.Email,
+		invitation.Hash,
+		pq.Array(invitation.Roles),
+		pq.Array(invitation.Permissions),
+		invitation.Expiry,
+		invitation.InvitedBy,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+}
+
+// GetAllPending retrieves the invitations which haven't been accepted and haven't expired
+func (m InvitationModel) GetAllPending() ([]*Invitation, error) {
+	query := `
+	SELECT id, created_at, email, roles, permissions, expiry, COALESCE(invited_by, 0), accepted_at
+	FROM invitations
+	WHERE accepted_at IS NULL AND expiry > $1
+	ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err = rows.Scan(
+			&invitation.ID,
+			&invitation.CreatedAt,
+			&invitation.Email,
+			pq.Array(&invitation.Roles),
+			pq.Array(&invitation.Permissions),
+			&invitation.Expiry,
+			&invitation.InvitedBy,
+			&invitation.AcceptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// GetForToken retrieves a pending invitation by its plaintext token
+func (m InvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	query := `
+	SELECT id, created_at, email, roles, permissions, expiry, COALESCE(invited_by, 0), accepted_at
+	FROM invitations
+	WHERE hash = $1 AND accepted_at IS NULL AND expiry > $2`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	invitation := Invitation{Plaintext: tokenPlaintext, Hash: tokenHash[:]}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.Email,
+		pq.Array(&invitation.Roles),
+		pq.Array(&invitation.Permissions),
+		&invitation.Expiry,
+		&invitation.InvitedBy,
+		&invitation.AcceptedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// Accept marks a pending invitation as accepted. ErrRecordNotFound is returned if it was accepted by a concurrent request,
+// so that each invitation can only be used once.
+func (m InvitationModel) Accept(invitation *Invitation) error {
+	query := `
+	UPDATE invitations
+	SET accepted_at = NOW()
+	WHERE id = $1 AND accepted_at IS NULL
+	RETURNING accepted_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, invitation.ID).Scan(&invitation.AcceptedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete revokes a pending invitation
+func (m InvitationModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM invitations
+	WHERE id = $1 AND accepted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
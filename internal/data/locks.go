@@ -0,0 +1,63 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// LockModel struct and methods for taking Postgres advisory locks, so that work is only done by one of several API instances at a time
+type LockModel struct {
+	DB *sql.DB
+}
+
+// lockKey derives the 64-bit advisory lock key for a lock name
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("greenlight:" + name))
+	return int64(h.Sum64())
+}
+
+// TryRun runs fn while holding the named advisory lock. If another session already holds the lock, fn isn't run and false is returned.
+// Advisory locks belong to the session, so the lock is taken and released on a single dedicated connection. Closing the
+// connection only returns it to the pool with the session still open, so if the lock can't be released the connection
+// is discarded instead, which ends the session and with it the lock.
+func (m LockModel) TryRun(name string, fn func() error) (ran bool, err error) {
+	ctx := context.Background()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	key := lockKey(name)
+
+	var acquired bool
+
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&acquired)
+	if err != nil || !acquired {
+		return false, err
+	}
+
+	// unlock even if fn panics, so the pooled connection never keeps the lock
+	defer func() {
+		var released bool
+
+		unlockErr := conn.QueryRowContext(ctx, `SELECT pg_advisory_unlock($1)`, key).Scan(&released)
+		if unlockErr == nil && !released {
+			unlockErr = fmt.Errorf("advisory lock %q wasn't held when releasing it", name)
+		}
+
+		if unlockErr != nil {
+			// returning driver.ErrBadConn makes the pool close the connection rather than reuse it
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			err = errors.Join(err, unlockErr)
+		}
+	}()
+
+	return true, fn()
+}
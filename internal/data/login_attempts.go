@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempt holds a failed login attempt
+type LoginAttempt struct {
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Lockout holds the temporary lockout state for an email address
+type Lockout struct {
+	Email        string
+	LockedUntil  time.Time
+	LockoutCount int
+}
+
+// Active checks if the lockout is still in effect
+func (l *Lockout) Active() bool {
+	return l != nil && time.Now().Before(l.LockedUntil)
+}
+
+// LockoutDuration returns the exponential backoff duration for the given lockout count, capped at max
+func LockoutDuration(count int, base, max time.Duration) time.Duration {
+	duration := base
+
+	for i := 1; i < count; i++ {
+		duration *= 2
+		if duration >= max {
+			return max
+		}
+	}
+
+	if duration > max {
+		return max
+	}
+
+	return duration
+}
+
+// LoginAttemptModel struct and methods for tracking failed logins and lockouts.
+// Attempts are keyed on the submitted email address (whether or not a user exists for it) so that responses don't reveal which emails are registered.
+type LoginAttemptModel struct {
+	DB DBTX
+}
+
+// Insert records a failed login attempt for an email address and IP
+func (m LoginAttemptModel) Insert(email, ip string) error {
+	query := `
+	INSERT INTO login_attempts (email, ip)
+	VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, ip)
+	return err
+}
+
+// CountForEmail returns the number of failed attempts for an email address since the given time
+func (m LoginAttemptModel) CountForEmail(email string, since time.Time) (int, error) {
+	query := `
+	SELECT count(*)
+	FROM login_attempts
+	WHERE email = $1 AND created_at > $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, email, since).Scan(&count)
+	return count, err
+}
+
+// CountForIP returns the number of failed attempts from an IP address since the given time
+func (m LoginAttemptModel) CountForIP(ip string, since time.Time) (int, error) {
+	query := `
+	SELECT count(*)
+	FROM login_attempts
+	WHERE ip = $1 AND created_at > $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, ip, since).Scan(&count)
+	return count, err
+}
+
+// GetAllForEmail retrieves all the failed attempts recorded for an email address
+func (m LoginAttemptModel) GetAllForEmail(email string) ([]*LoginAttempt, error) {
+	query := `
+	SELECT ip, created_at
+	FROM login_attempts
+	WHERE email = $1
+	ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*LoginAttempt
+
+	for rows.Next() {
+		var attempt LoginAttempt
+
+		err = rows.Scan(&attempt.IP, &attempt.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, &attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// DeleteAllForEmail clears the failed attempts and lockout for an email address. Deleting the lockout also resets the
+// backoff, which is intended: it's called after a successful login, when an admin unlocks the account and when the
+// account is deleted, and in each case earlier failures shouldn't make the next lockout longer.
+func (m LoginAttemptModel) DeleteAllForEmail(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_attempts WHERE email = $1`, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM login_lockouts WHERE email = $1`, email)
+	return err
+}
+
+// DeleteBefore deletes up to limit failed attempts recorded before the given time, and returns how many were deleted
+func (m LoginAttemptModel) DeleteBefore(before time.Time, limit int) (int64, error) {
+	query := `
+	DELETE FROM login_attempts
+	WHERE id IN (
+		SELECT id FROM login_attempts
+		WHERE created_at < $1
+		LIMIT $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteLockoutsBefore deletes up to limit lockouts which ended before the given time, and returns how many were deleted.
+// The backoff starts again from the first lockout for those email addresses.
+func (m LoginAttemptModel) DeleteLockoutsBefore(before time.Time, limit int) (int64, error) {
+	query := `
+	DELETE FROM login_lockouts
+	WHERE email IN (
+		SELECT email FROM login_lockouts
+		WHERE locked_until < $1
+		LIMIT $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetLockout retrieves the lockout for an email address
+func (m LoginAttemptModel) GetLockout(email string) (*Lockout, error) {
+	query := `
+	SELECT email, locked_until, lockout_count
+	FROM login_lockouts
+	WHERE email = $1`
+
+	var lockout Lockout
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&lockout.Email,
+		&lockout.LockedUntil,
+		&lockout.LockoutCount,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &lockout, nil
+}
+
+// Lock creates or extends the lockout for an email address
+func (m LoginAttemptModel) Lock(lockout *Lockout) error {
+	query := `
+	INSERT INTO login_lockouts (email, locked_until, lockout_count)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (email) DO UPDATE
+	SET locked_until = EXCLUDED.locked_until, lockout_count = EXCLUDED.lockout_count`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, lockout.Email, lockout.LockedUntil, lockout.LockoutCount)
+	return err
+}
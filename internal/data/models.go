@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -10,18 +11,77 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so that the models can run their queries inside a transaction
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies        MovieModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	Roles         RoleModel
+	LoginAttempts LoginAttemptModel
+	Exports       ExportModel
+	OAuth         OAuthModel
+	Locks         LockModel
+	Invitations   InvitationModel
+	Audit         AuditModel
+	Outbox        OutboxModel
+	Notifications NotificationModel
+	Suppressions  SuppressionModel
+
+	db *sql.DB
 }
 
 func NewModels(db *sql.DB) Models {
+	models := newModels(db)
+	models.Locks = LockModel{DB: db}
+	models.db = db
+
+	return models
+}
+
+// newModels returns the models which can run their queries inside a transaction
+func newModels(db DBTX) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		Users:         UserModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Roles:         RoleModel{DB: db},
+		LoginAttempts: LoginAttemptModel{DB: db},
+		Exports:       ExportModel{DB: db},
+		OAuth:         OAuthModel{DB: db},
+		Invitations:   InvitationModel{DB: db},
+		Audit:         AuditModel{DB: db},
+		Outbox:        OutboxModel{DB: db},
+		Notifications: NotificationModel{DB: db},
+		Suppressions:  SuppressionModel{DB: db},
 	}
 }
+
+// Transaction runs fn with a copy of the models whose queries all run in a single transaction. The transaction is
+// committed if fn returns nil and rolled back otherwise. Transactions can't be nested.
+func (m Models) Transaction(fn func(tx Models) error) error {
+	tx, err := m.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	txModels := newModels(tx)
+	txModels.Locks = m.Locks
+
+	err = fn(txModels)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
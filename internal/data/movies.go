@@ -19,7 +19,28 @@ type Movie struct {
 	Year      int32     `json:"year,omitempty"`    // release year
 	Runtime   Runtime   `json:"runtime,omitempty"` // movie run time (in minutes)
 	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"` // the version number starts at 1 and will be incremented each time the movie information is updated
+	Owner     *Owner    `json:"owner,omitempty"` // the user who created the movie, nil if unknown or the account was deleted
+	Version   int32     `json:"version"`         // the version number starts at 1 and will be incremented each time the movie information is updated
+}
+
+// Owner identifies the user who owns a record
+type Owner struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// OwnedBy checks if the movie is owned by the given user
+func (movie *Movie) OwnedBy(userID int64) bool {
+	return movie.Owner != nil && movie.Owner.ID == userID
+}
+
+// scanOwner builds the owner of a record from the nullable columns of a LEFT JOIN on users
+func scanOwner(id sql.NullInt64, name sql.NullString) *Owner {
+	if !id.Valid {
+		return nil
+	}
+
+	return &Owner{ID: id.Int64, Name: name.String}
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -38,14 +59,19 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 // MovieModel defines the methods for interacting with movies data.
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // Insert will insert a new movie into the database.
 func (m MovieModel) Insert(movie *Movie) error {
-	query := `INSERT INTO movies (title, year, runtime, genres) VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`
+	query := `INSERT INTO movies (title, year, runtime, genres, created_by) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, version`
 
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	var createdBy *int64
+	if movie.Owner != nil {
+		createdBy = &movie.Owner.ID
+	}
+
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), createdBy}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -60,9 +86,16 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 
 	query := `
-	SELECT id, created_at, title, year, runtime, genres, version FROM movies WHERE id = $1`
-
-	var movie Movie
+	SELECT movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, users.id, users.name, movies.version
+	FROM movies
+	LEFT JOIN users ON users.id = movies.created_by
+	WHERE movies.id = $1`
+
+	var (
+		movie     Movie
+		ownerID   sql.NullInt64
+		ownerName sql.NullString
+	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -75,6 +108,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&ownerID,
+		&ownerName,
 		&movie.Version,
 	)
 
@@ -87,6 +122,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
+	movie.Owner = scanOwner(ownerID, ownerName)
+
 	return &movie, nil
 }
 
@@ -104,6 +141,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Runtime,
 		pq.Array(movie.Genres),
 		movie.ID,
+		movie.Version,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -121,6 +159,29 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
+// UpdateOwner will reassign the movie to the user in movie.Owner.
+func (m MovieModel) UpdateOwner(movie *Movie) error {
+	query := `
+	UPDATE movies
+	SET created_by = $1, version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movie.Owner.ID, movie.ID, movie.Version).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
 // Delete will delete a movie from the database.
 func (m MovieModel) Delete(id int64) error {
 	if id < 1 {
@@ -149,20 +210,23 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// GetAll returns the movies matching the title, genres and owner filters (an ownerID of 0 matches every owner).
+func (m MovieModel) GetAll(title string, genres []string, ownerID int64, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	SELECT count(*) OVER(), movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, users.id, users.name, movies.version
 FROM movies
+LEFT JOIN users ON users.id = movies.created_by
 WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 AND (genres @> $2 OR $2 = '{}')
-ORDER BY %s %s, id ASC
+AND (movies.created_by = $5 OR $5 = 0)
+ORDER BY movies.%s %s, movies.id ASC
 LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	args := []interface{}{
-		title, pq.Array(genres), filters.limit(), filters.offset(),
+		title, pq.Array(genres), filters.limit(), filters.offset(), ownerID,
 	}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
@@ -176,7 +240,11 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	var movies []*Movie
 
 	for rows.Next() {
-		var movie Movie
+		var (
+			movie     Movie
+			ownerID   sql.NullInt64
+			ownerName sql.NullString
+		)
 
 		err := rows.Scan(
 			&totalRecords,
@@ -186,6 +254,8 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&ownerID,
+			&ownerName,
 			&movie.Version,
 		)
 
@@ -193,6 +263,8 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 			return nil, Metadata{}, err
 		}
 
+		movie.Owner = scanOwner(ownerID, ownerName)
+
 		movies = append(movies, &movie)
 	}
 
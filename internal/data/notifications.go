@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	NotificationPasswordChanged    = "password_changed"
+	NotificationNewSignIn          = "new_sign_in"
+	NotificationPermissionsGranted = "permissions_granted"
+)
+
+// OptionalNotifications lists the security notifications users can opt out of. Password changes are always notified.
+var OptionalNotifications = []string{NotificationNewSignIn, NotificationPermissionsGranted}
+
+// NotificationModel struct and methods for the notifications users have opted out of
+type NotificationModel struct {
+	DB DBTX
+}
+
+// GetOptOutsForUser returns the notifications a user has opted out of
+func (m NotificationModel) GetOptOutsForUser(userID int64) ([]string, error) {
+	query := `
+	SELECT notification
+	FROM notification_opt_outs
+	WHERE user_id = $1
+	ORDER BY notification`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []string{}
+
+	for rows.Next() {
+		var notification string
+
+		err = rows.Scan(&notification)
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// OptedOut checks if a user has opted out of a notification
+func (m NotificationModel) OptedOut(userID int64, notification string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM notification_opt_outs
+		WHERE user_id = $1 AND notification = $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var optedOut bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID, notification).Scan(&optedOut)
+	return optedOut, err
+}
+
+// SetOptOuts replaces the notifications a user has opted out of. It should be run inside a transaction.
+func (m NotificationModel) SetOptOuts(userID int64, notifications []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM notification_opt_outs WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO notification_opt_outs (user_id, notification)
+	SELECT $1, unnest($2::text[])
+	ON CONFLICT DO NOTHING`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, pq.Array(notifications))
+	return err
+}
@@ -0,0 +1,347 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blessedmadukoma/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// OAuthScopes maps each OAuth scope a client can request onto the permission codes it grants. A token only ever gets the
+// permissions the user actually holds, so a scope narrows what a client can do on the user's behalf but never widens it.
+var OAuthScopes = map[string]Permissions{
+	"profile":      {},
+	"movies:read":  {"movies:read"},
+	"movies:write": {"movies:write", "movies:write:own"},
+}
+
+// PermissionsForScopes returns the permission codes granted by a set of OAuth scopes
+func PermissionsForScopes(scopes []string) Permissions {
+	permissions := Permissions{}
+
+	for _, scope := range scopes {
+		permissions = append(permissions, OAuthScopes[scope]...)
+	}
+
+	return permissions
+}
+
+var codeVerifierRX = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// OAuthClient is a third-party application which can act on behalf of users
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	Secret       string    `json:"client_secret,omitempty"` // only set in the response to the registration request
+	SecretHash   []byte    `json:"-"`                       // nil for public clients, which must rely on PKCE alone
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"` // the most a client can ask a user for
+	UserID       int64     `json:"-"`      // the user who registered the client, and who client_credentials tokens act as
+}
+
+// Confidential checks if the client was issued a secret
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != nil
+}
+
+// SecretMatches checks the plaintext client secret against the stored hash
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	if !c.Confidential() {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(hash[:], c.SecretHash) == 1
+}
+
+// HasRedirectURI checks if a redirect URI exactly matches one registered for the client
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	return validator.In(uri, c.RedirectURIs...)
+}
+
+// generateCredentials sets a random client ID, and a random secret for confidential clients
+func (c *OAuthClient) generateCredentials(confidential bool) error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	c.ID = strings.ToLower(encoding.EncodeToString(randomBytes[:10]))
+
+	if confidential {
+		c.Secret = encoding.EncodeToString(randomBytes[10:])
+		hash := sha256.Sum256([]byte(c.Secret))
+		c.SecretHash = hash[:]
+	}
+
+	return nil
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(client.RedirectURIs) >= 1, "redirect_uris", "must contain at least 1 uri")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 uris")
+	v.Check(validator.Unique(client.RedirectURIs), "redirect_uris", "must not contain duplicate values")
+
+	for _, uri := range client.RedirectURIs {
+		u, err := url.Parse(uri)
+		v.Check(err == nil && u.IsAbs() && u.Fragment == "", "redirect_uris", "must only contain absolute uris without a fragment")
+		v.Check(err == nil && (u.Scheme == "https" || u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"), "redirect_uris", "must use https unless pointing at localhost")
+	}
+
+	v.Check(len(client.Scopes) >= 1, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(client.Scopes), "scopes", "must not contain duplicate values")
+
+	for _, scope := range client.Scopes {
+		_, ok := OAuthScopes[scope]
+		v.Check(ok, "scopes", "must only contain known scopes")
+	}
+}
+
+// ValidCodeVerifier checks that a PKCE code verifier is well-formed as per RFC 7636
+func ValidCodeVerifier(verifier string) bool {
+	return codeVerifierRX.MatchString(verifier)
+}
+
+// OAuthCode is a single-use authorization code, bound to a client, redirect URI and PKCE code challenge
+type OAuthCode struct {
+	Plaintext     string
+	Hash          []byte
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string // the S256 challenge, i.e. BASE64URL(SHA256(code_verifier))
+	Expiry        time.Time
+}
+
+// VerifierMatches checks a PKCE code verifier against the code's S256 challenge
+func (c *OAuthCode) VerifierMatches(verifier string) bool {
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(c.CodeChallenge)) == 1
+}
+
+// OAuthModel struct and methods for interacting with the OAuth clients and authorization codes in the DB
+type OAuthModel struct {
+	DB DBTX
+}
+
+// InsertClient generates credentials for a new client and inserts it into the database. The plaintext secret is only available on the returned struct.
+func (m OAuthModel) InsertClient(client *OAuthClient, confidential bool) error {
+	err := client.generateCredentials(confidential)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, scopes, user_id)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING created_at`
+
+	args := []interface{}{
+		client.ID,
+		client.Name,
+		client.SecretHash,
+		pq.Array(client.RedirectURIs),
+		pq.Array(client.Scopes),
+		client.UserID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.CreatedAt)
+}
+
+// GetClient retrieves a client by its client ID
+func (m OAuthModel) GetClient(id string) (*OAuthClient, error) {
+	query := `
+	SELECT id, created_at, name, secret_hash, redirect_uris, scopes, user_id
+	FROM oauth_clients
+	WHERE id = $1`
+
+	var client OAuthClient
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&client.ID,
+		&client.CreatedAt,
+		&client.Name,
+		&client.SecretHash,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+		&client.UserID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &client, nil
+}
+
+// GetClientsForUser retrieves all the clients registered by a specific user
+func (m OAuthModel) GetClientsForUser(userID int64) ([]*OAuthClient, error) {
+	query := `
+	SELECT id, created_at, name, secret_hash, redirect_uris, scopes, user_id
+	FROM oauth_clients
+	WHERE user_id = $1
+	ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		var client OAuthClient
+
+		err = rows.Scan(
+			&client.ID,
+			&client.CreatedAt,
+			&client.Name,
+			&client.SecretHash,
+			pq.Array(&client.RedirectURIs),
+			pq.Array(&client.Scopes),
+			&client.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, &client)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// DeleteClient deletes a client registered by a specific user, which also revokes every token issued to it
+func (m OAuthModel) DeleteClient(id string, userID int64) error {
+	query := `
+	DELETE FROM oauth_clients
+	WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// NewCode generates a new authorization code and inserts it into the database
+func (m OAuthModel) NewCode(code *OAuthCode, ttl time.Duration) error {
+	token, err := generateToken(code.UserID, ttl, "")
+	if err != nil {
+		return err
+	}
+
+	code.Plaintext = token.Plaintext
+	code.Hash = token.Hash
+	code.Expiry = token.Expiry
+
+	query := `
+	INSERT INTO oauth_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []interface{}{
+		code.Hash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		pq.Array(code.Scopes),
+		code.CodeChallenge,
+		code.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ConsumeCode deletes an unexpired authorization code issued to a client and returns it, so that each code can only be exchanged once
+func (m OAuthModel) ConsumeCode(clientID, codePlaintext string) (*OAuthCode, error) {
+	query := `
+	DELETE FROM oauth_codes
+	WHERE hash = $1 AND client_id = $2 AND expiry > $3
+	RETURNING hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry`
+
+	hash := sha256.Sum256([]byte(codePlaintext))
+
+	code := OAuthCode{Plaintext: codePlaintext}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], clientID, time.Now()).Scan(
+		&code.Hash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// the states of an email in the outbox
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead" // gave up after the maximum number of attempts
+)
+
+// OutboxEmail is an email waiting to be sent, written in the same transaction as the change it's about so that it can't be lost
+type OutboxEmail struct {
+	ID            int64
+	CreatedAt     time.Time
+	Recipient     string
+	Locale        string
+	Template      string
+	Data          json.RawMessage // the template data, encoded as JSON
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+// OutboxDepth holds the number of emails in the outbox which are waiting to be sent or have been given up on
+type OutboxDepth struct {
+	Pending int `json:"pending"`
+	Dead    int `json:"dead"`
+}
+
+// OutboxModel struct and methods for interacting with the email outbox in the DB
+type OutboxModel struct {
+	DB DBTX
+}
+
+// Enqueue adds an email to the outbox, to be rendered from the template for the locale with data and sent by the outbox
+// workers. data is stored as JSON, so it must be the template's data struct rather than anything holding references.
+func (m OutboxModel) Enqueue(recipient, locale, template string, data interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO email_outbox (recipient, locale, template, data)
+	VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, recipient, locale, template, js)
+	return err
+}
+
+// Claim leases up to limit emails which are due to be sent. Each claimed email's attempt count is incremented and its
+// next attempt pushed back by lease, so that if the process dies mid-send the email is retried once the lease runs out.
+// SKIP LOCKED lets several workers and instances claim emails at the same time without getting the same ones.
+func (m OutboxModel) Claim(limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	query := `
+	UPDATE email_outbox
+	SET attempts = attempts + 1, next_attempt_at = $1
+	WHERE id IN (
+		SELECT id FROM email_outbox
+		WHERE status = 'pending' AND next_attempt_at <= $2
+		ORDER BY next_attempt_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, created_at, recipient, locale, template, data, status, attempts, next_attempt_at, last_error`
+
+	now := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []*OutboxEmail
+
+	for rows.Next() {
+		var email OutboxEmail
+
+		err = rows.Scan(
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Locale,
+			&email.Template,
+			&email.Data,
+			&email.Status,
+			&email.Attempts,
+			&email.NextAttemptAt,
+			&email.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that an email was sent. The template data is cleared, as it can hold tokens which shouldn't be
+// readable from the database once they've been delivered.
+func (m OutboxModel) MarkSent(email *OutboxEmail) error {
+	query := `
+	UPDATE email_outbox
+	SET status = 'sent', sent_at = NOW(), last_error = '', data = '{}'
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email.ID)
+	if err != nil {
+		return err
+	}
+
+	email.Status = OutboxSent
+	email.Data = json.RawMessage("{}")
+
+	return nil
+}
+
+// MarkFailed records a failed attempt to send an email, either scheduling the next attempt or, if dead is set, giving up on it.
+// A dead email's template data is cleared like a sent one's, and next_attempt_at records when it was given up on.
+func (m OutboxModel) MarkFailed(email *OutboxEmail, sendErr error, nextAttemptAt time.Time, dead bool) error {
+	query := `
+	UPDATE email_outbox
+	SET status = $1, next_attempt_at = $2, last_error = $3, data = CASE WHEN $1 = 'dead' THEN '{}' ELSE data END
+	WHERE id = $4`
+
+	email.Status = OutboxPending
+	if dead {
+		email.Status = OutboxDead
+		email.Data = json.RawMessage("{}")
+		nextAttemptAt = time.Now()
+	}
+
+	email.NextAttemptAt = nextAttemptAt
+	email.LastError = sendErr.Error()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email.Status, email.NextAttemptAt, email.LastError, email.ID)
+	return err
+}
+
+// Depth returns the number of pending and dead emails in the outbox
+func (m OutboxModel) Depth() (OutboxDepth, error) {
+	query := `
+	SELECT count(*) FILTER (WHERE status = 'pending'), count(*) FILTER (WHERE status = 'dead')
+	FROM email_outbox`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var depth OutboxDepth
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&depth.Pending, &depth.Dead)
+	return depth, err
+}
+
+// DeleteFinished deletes up to limit emails which were sent or given up on before the given time, and returns how many were deleted
+func (m OutboxModel) DeleteFinished(before time.Time, limit int) (int64, error) {
+	query := `
+	DELETE FROM email_outbox
+	WHERE id IN (
+		SELECT id FROM email_outbox
+		WHERE (status = 'sent' AND sent_at < $1) OR (status = 'dead' AND next_attempt_at < $1)
+		LIMIT $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	SuppressionHardBounce = "hard_bounce"
+	SuppressionComplaint  = "complaint"
+)
+
+// Suppression is an email address which mail is no longer sent to, because it bounced or its owner complained
+type Suppression struct {
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details"`
+}
+
+// SuppressionModel struct and methods for the list of suppressed email addresses
+type SuppressionModel struct {
+	DB DBTX
+}
+
+// Insert adds an email address to the suppression list, replacing the reason if it's already suppressed
+func (m SuppressionModel) Insert(suppression *Suppression) error {
+	query := `
+	INSERT INTO email_suppressions (email, reason, details)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (email) DO UPDATE SET reason = EXCLUDED.reason, details = EXCLUDED.details
+	RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, suppression.Email, suppression.Reason, suppression.Details).Scan(&suppression.CreatedAt)
+}
+
+// Suppressed checks if an email address is on the suppression list
+func (m SuppressionModel) Suppressed(email string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM email_suppressions
+		WHERE email = $1
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var suppressed bool
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&suppressed)
+	return suppressed, err
+}
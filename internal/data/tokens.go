@@ -6,23 +6,42 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/blessedmadukoma/greenlight/internal/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordRest   = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeOAuthRefresh   = "oauth-refresh"
+	ScopeMagicLink      = "magic-link"
+	ScopeSessionRevoke  = "session-revoke"
 )
 
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"` // hide the hash from the JSON output
-	UserID    int64     `json:"-"` // hide the user ID from the JSON output
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"` // hide the scope from the JSON output
+	Plaintext      string    `json:"token"`
+	Hash           []byte    `json:"-"` // hide the hash from the JSON output
+	UserID         int64     `json:"-"` // hide the user ID from the JSON output
+	Expiry         time.Time `json:"expiry"`
+	Scope          string    `json:"-"` // hide the scope from the JSON output
+	ClientID       string    `json:"-"` // the OAuth client the token was issued to, empty for first-party tokens
+	OAuthScopes    []string  `json:"-"` // the OAuth scopes granted to the client, nil for first-party tokens
+	ImpersonatorID int64     `json:"-"` // the admin acting as the user, zero unless the token is for impersonation
+}
+
+// IsImpersonation checks if the token was issued to an admin to act as the user
+func (t *Token) IsImpersonation() bool {
+	return t != nil && t.ImpersonatorID != 0
+}
+
+// IsOAuth checks if the token was issued to a third-party OAuth client
+func (t *Token) IsOAuth() bool {
+	return t != nil && t.ClientID != ""
 }
 
 // generateToken generates token using Go’s crypto/rand package and 128-bits (16 bytes) of entropy based on the scope
@@ -58,7 +77,7 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // New creates a new token struct and inserts the token data into the tokens table
@@ -72,13 +91,43 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewForClient creates a new token issued to an OAuth client with the granted OAuth scopes, and inserts it into the tokens table
+func (m TokenModel) NewForClient(userID int64, ttl time.Duration, scope, clientID string, oauthScopes []string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientID = clientID
+	token.OAuthScopes = oauthScopes
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewImpersonation creates a new authentication token which lets an admin act as a user, and inserts it into the tokens table
+func (m TokenModel) NewImpersonation(userID, impersonatorID int64, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ImpersonatorID = impersonatorID
+
+	err = m.Insert(token)
+	return token, err
+}
+
 // Insert	inserts the token data into the tokens table
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, client_id, oauth_scopes, impersonator_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	clientID := sql.NullString{String: token.ClientID, Valid: token.ClientID != ""}
+	impersonatorID := sql.NullInt64{Int64: token.ImpersonatorID, Valid: token.ImpersonatorID != 0}
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, clientID, pq.Array(token.OAuthScopes), impersonatorID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -87,6 +136,76 @@ func (m TokenModel) Insert(token *Token) error {
 	return err
 }
 
+// Get retrieves an unexpired token by scope and plaintext
+func (m TokenModel) Get(scope, tokenPlaintext string) (*Token, error) {
+	query := `
+		SELECT hash, user_id, expiry, scope, client_id, oauth_scopes, impersonator_id
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	token := Token{Plaintext: tokenPlaintext}
+
+	var (
+		clientID       sql.NullString
+		impersonatorID sql.NullInt64
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&clientID,
+		pq.Array(&token.OAuthScopes),
+		&impersonatorID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	token.ClientID = clientID.String
+	token.ImpersonatorID = impersonatorID.Int64
+
+	return &token, nil
+}
+
+// Delete deletes a single token
+func (m TokenModel) Delete(token *Token) error {
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, token.Hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 // DeleteAllForUser deletes all tokens for a specific user and scope
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
@@ -99,3 +218,100 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllForUserExcept deletes all tokens for a specific user and scope, apart from the given token
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, tokenPlaintext string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND hash != $3`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, tokenHash[:])
+	return err
+}
+
+// LatestExpiryForUser returns the latest expiry of the unexpired tokens for a specific user and scope, or the zero time if there are none
+func (m TokenModel) LatestExpiryForUser(scope string, userID int64) (time.Time, error) {
+	query := `
+		SELECT COALESCE(MAX(expiry), 'epoch')
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var expiry time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, scope, userID, time.Now()).Scan(&expiry)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if expiry.Unix() == 0 {
+		return time.Time{}, nil
+	}
+
+	return expiry, nil
+}
+
+// DeleteExpired deletes up to limit expired tokens, and returns how many were deleted
+func (m TokenModel) DeleteExpired(limit int) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE hash IN (
+			SELECT hash FROM tokens
+			WHERE expiry <= $1
+			LIMIT $2
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetAllForUser retrieves the metadata of all unexpired tokens for a specific user. The plaintext and hash are never returned.
+func (m TokenModel) GetAllForUser(userID int64) ([]*Token, error) {
+	query := `
+		SELECT user_id, expiry, scope
+		FROM tokens
+		WHERE user_id = $1 AND expiry > $2
+		ORDER BY expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*Token
+
+	for rows.Next() {
+		var token Token
+
+		err = rows.Scan(&token.UserID, &token.Expiry, &token.Scope)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenStore is a minimal database/sql driver which understands just enough SQL to stand in for the tokens table:
+// an INSERT stores the listed columns keyed by the first argument (the hash), and a SELECT returns the listed
+// columns of the row whose hash is the first argument. Columns which weren't inserted come back as NULL, and
+// selecting a different number of columns than are scanned fails, as it would with PostgreSQL.
+type tokenStore struct {
+	mu   sync.Mutex
+	rows map[string]map[string]driver.Value
+}
+
+var (
+	insertColumnsRX = regexp.MustCompile(`(?s)INSERT INTO tokens \((.*?)\)`)
+	selectColumnsRX = regexp.MustCompile(`(?s)SELECT (.*?)\s+FROM tokens`)
+)
+
+func splitColumns(list string) []string {
+	columns := strings.Split(list, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+
+	return columns
+}
+
+func (s *tokenStore) Open(string) (driver.Conn, error) { return s, nil }
+
+func (s *tokenStore) Prepare(query string) (driver.Stmt, error) {
+	return &tokenStmt{store: s, query: query}, nil
+}
+
+func (s *tokenStore) Close() error { return nil }
+
+func (s *tokenStore) Begin() (driver.Tx, error) { return nil, errors.New("transactions not supported") }
+
+type tokenStmt struct {
+	store *tokenStore
+	query string
+}
+
+func (st *tokenStmt) Close() error  { return nil }
+func (st *tokenStmt) NumInput() int { return -1 }
+
+func (st *tokenStmt) Exec(args []driver.Value) (driver.Result, error) {
+	match := insertColumnsRX.FindStringSubmatch(st.query)
+	if match == nil {
+		return nil, errors.New("unsupported query: " + st.query)
+	}
+
+	columns := splitColumns(match[1])
+	if len(columns) != len(args) {
+		return nil, errors.New("column and argument counts differ")
+	}
+
+	row := map[string]driver.Value{}
+	for i, column := range columns {
+		row[column] = args[i]
+	}
+
+	st.store.mu.Lock()
+	defer st.store.mu.Unlock()
+
+	st.store.rows[string(args[0].([]byte))] = row
+
+	return driver.RowsAffected(1), nil
+}
+
+func (st *tokenStmt) Query(args []driver.Value) (driver.Rows, error) {
+	match := selectColumnsRX.FindStringSubmatch(st.query)
+	if match == nil {
+		return nil, errors.New("unsupported query: " + st.query)
+	}
+
+	st.store.mu.Lock()
+	defer st.store.mu.Unlock()
+
+	rows := &tokenRows{columns: splitColumns(match[1])}
+
+	if row, ok := st.store.rows[string(args[0].([]byte))]; ok && row["scope"] == args[1] {
+		values := make([]driver.Value, len(rows.columns))
+		for i, column := range rows.columns {
+			values[i] = row[column]
+		}
+		rows.values = [][]driver.Value{values}
+	}
+
+	return rows, nil
+}
+
+type tokenRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *tokenRows) Columns() []string { return r.columns }
+func (r *tokenRows) Close() error      { return nil }
+
+func (r *tokenRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+var registerTokenStore sync.Once
+
+func newTokenModel(t *testing.T) TokenModel {
+	registerTokenStore.Do(func() {
+		sql.Register("tokenstore", &tokenStore{rows: map[string]map[string]driver.Value{}})
+	})
+
+	db, err := sql.Open("tokenstore", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return TokenModel{DB: db}
+}
+
+func TestImpersonationTokenRoundTrip(t *testing.T) {
+	m := newTokenModel(t)
+
+	token, err := m.NewImpersonation(7, 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Get(ScopeAuthentication, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.UserID != 7 {
+		t.Errorf("got user ID %d, want 7", got.UserID)
+	}
+
+	if got.ImpersonatorID != 3 || !got.IsImpersonation() {
+		t.Errorf("got impersonator ID %d, want 3", got.ImpersonatorID)
+	}
+}
+
+func TestTokenRoundTripWithoutImpersonator(t *testing.T) {
+	m := newTokenModel(t)
+
+	token, err := m.NewForClient(7, time.Hour, ScopeAuthentication, "client", []string{"profile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Get(ScopeAuthentication, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.IsImpersonation() {
+		t.Errorf("got impersonator ID %d, want none", got.ImpersonatorID)
+	}
+
+	if got.ClientID != "client" || len(got.OAuthScopes) != 1 || got.OAuthScopes[0] != "profile" {
+		t.Errorf("got client %q with scopes %v, want client with [profile]", got.ClientID, got.OAuthScopes)
+	}
+}
@@ -2,9 +2,12 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/blessedmadukoma/greenlight/internal/validator"
@@ -14,13 +17,18 @@ import (
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64    `json:"id"`
-	CreatedAt string   `json:"created_at"`
-	Name      string   `json:"name"`
-	Email     string   `json:"email"`
-	Password  password `json:"-"` // "-" prevents the field from showing up in any output when encoding to JSON
-	Activated bool     `json:"activated"`
-	Version   int64    `json:"-"` // "-" prevents the field from showing up in any output when encoding to JSON
+	ID                  int64      `json:"id"`
+	CreatedAt           string     `json:"created_at"`
+	Name                string     `json:"name"`
+	Email               string     `json:"email"`
+	PendingEmail        string     `json:"pending_email,omitempty"` // new email address awaiting confirmation
+	Password            password   `json:"-"`                       // "-" prevents the field from showing up in any output when encoding to JSON
+	Activated           bool       `json:"activated"`
+	Suspended           bool       `json:"suspended"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"` // set when the user has asked for their account to be deleted
+	Locale              string     `json:"locale"`                          // language the user's emails are sent in
+	EmailBounced        bool       `json:"email_bounced"`                   // set when mail to the user's email address hard bounced
+	Version             int64      `json:"-"`                               // "-" prevents the field from showing up in any output when encoding to JSON
 }
 
 // IsAnonymous checks if a User instance is the anonymous user
@@ -37,6 +45,14 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// dummyPasswordHash is compared against when no user matches an email address, so that the lookup takes as long as a real password check
+var dummyPasswordHash = []byte("$2a$12$3kJ5HBbhOCNoLDTCD1hrwe5J6Uw27wuDPccDpBG3lyuh6oLYcTjL2")
+
+// SimulatePasswordCheck runs a bcrypt comparison against a dummy hash to prevent user enumeration via response timing
+func SimulatePasswordCheck(plaintext string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintext))
+}
+
 // Set sets the password to the given plaintext value.
 func (p *password) Set(plaintext string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), 12)
@@ -50,6 +66,26 @@ func (p *password) Set(plaintext string) error {
 	return nil
 }
 
+// SetUnusable sets the password to a random value that nobody knows, so that the user has to reset it before signing in again
+func (p *password) SetUnusable() error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(base64.StdEncoding.EncodeToString(randomBytes)), 12)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = nil
+	p.hash = hash
+
+	return nil
+}
+
 // Matches	returns true if the given plaintext value matches the password hash.
 func (p *password) Matches(plaintext string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintext))
@@ -94,13 +130,14 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 // UserModel struct and methods for interacting with the DB
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
+// Insert inserts a new user. activated_at is set if the user is created activated.
 func (m UserModel) Insert(user *User) error {
 	query := `
-	INSERT INTO users (name, email, password_hash, activated)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO users (name, email, password_hash, activated, locale, activated_at)
+	VALUES ($1, $2, $3, $4, $5, CASE WHEN $4 THEN NOW() END)
 	RETURNING id, created_at, version`
 
 	args := []interface{}{
@@ -108,6 +145,7 @@ func (m UserModel) Insert(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Locale,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -116,7 +154,7 @@ func (m UserModel) Insert(user *User) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -126,10 +164,53 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get gets user via the id
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, email, pending_email, password_hash, activated, suspended, deletion_scheduled_at, locale, email_bounced, version
+	FROM users
+	WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.PendingEmail,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.DeletionScheduledAt,
+		&user.Locale,
+		&user.EmailBounced,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // GetByEmail gets user via the email
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, created_at, name, email, password_hash, activated, version
+	SELECT id, created_at, name, email, pending_email, password_hash, activated, suspended, deletion_scheduled_at, locale, email_bounced, version
 	FROM users
 	WHERE email=$1`
 
@@ -143,8 +224,13 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Suspended,
+		&user.DeletionScheduledAt,
+		&user.Locale,
+		&user.EmailBounced,
 		&user.Version,
 	)
 
@@ -160,19 +246,89 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// Update updates the user information
+// GetAll returns the users matching the name, email and activated filters (empty strings and a nil activated match everything).
+// The name and email filters are case-insensitive substring matches; strpos is used rather than ILIKE so % and _ match literally.
+func (m UserModel) GetAll(name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, pending_email, password_hash, activated, suspended, deletion_scheduled_at, locale, email_bounced, version
+	FROM users
+	WHERE (strpos(lower(name), lower($1)) > 0 OR $1 = '')
+	AND (strpos(lower(email), lower($2)) > 0 OR $2 = '')
+	AND ($3::boolean IS NULL OR activated = $3)
+	ORDER BY %s %s, id ASC
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		name, email, activated, filters.limit(), filters.offset(),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.PendingEmail,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Suspended,
+			&user.DeletionScheduledAt,
+			&user.Locale,
+			&user.EmailBounced,
+			&user.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// Update updates the user information. activated_at records when the user was first activated, and is kept if they're deactivated.
 func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
-	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, pending_email = $3, password_hash = $4, activated = $5, suspended = $6, deletion_scheduled_at = $7, locale = $8, email_bounced = $9,
+		activated_at = CASE WHEN $5 AND activated_at IS NULL THEN NOW() ELSE activated_at END, version = version + 1
+	WHERE id = $10 AND version = $11
 	RETURNING version`
 
 	args := []interface{}{
 		user.Name,
 		user.Email,
+		user.PendingEmail,
 		user.Password.hash,
 		user.Activated,
+		user.Suspended,
+		user.DeletionScheduledAt,
+		user.Locale,
+		user.EmailBounced,
 		user.ID,
 		user.Version,
 	}
@@ -200,7 +356,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 	query := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.id, users.created_at, users.name, users.email, users.pending_email, users.password_hash, users.activated, users.suspended, users.deletion_scheduled_at, users.locale, users.email_bounced, users.version
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
@@ -224,8 +380,13 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Suspended,
+		&user.DeletionScheduledAt,
+		&user.Locale,
+		&user.EmailBounced,
 		&user.Version,
 	)
 
@@ -240,3 +401,83 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 
 	return &user, nil
 }
+
+// FlagBounced marks the email address of the user it belongs to as bounced, and returns the user's ID.
+// ErrRecordNotFound is returned if no user has the address or it's already flagged.
+func (m UserModel) FlagBounced(email string) (int64, error) {
+	query := `
+	UPDATE users
+	SET email_bounced = true, version = version + 1
+	WHERE email = $1 AND email_bounced = false
+	RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// DeleteScheduled permanently deletes the users whose deletion grace period ended before the given time, and returns their email addresses.
+// Tokens, permissions and exports are removed by the ON DELETE CASCADE foreign keys.
+func (m UserModel) DeleteScheduled(before time.Time) ([]string, error) {
+	query := `
+	DELETE FROM users
+	WHERE deletion_scheduled_at <= $1
+	RETURNING email`
+
+	return m.deleteReturningEmails(query, before)
+}
+
+// DeleteUnactivated permanently deletes the accounts created before the given time which were never activated, and returns their email addresses.
+// Users who were activated and have since been deactivated by an admin are kept.
+func (m UserModel) DeleteUnactivated(createdBefore time.Time) ([]string, error) {
+	query := `
+	DELETE FROM users
+	WHERE activated = false AND activated_at IS NULL AND created_at <= $1
+	RETURNING email`
+
+	return m.deleteReturningEmails(query, createdBefore)
+}
+
+// deleteReturningEmails runs a DELETE ... RETURNING email query and collects the email addresses of the deleted users
+func (m UserModel) deleteReturningEmails(query string, args ...interface{}) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []string
+
+	for rows.Next() {
+		var email string
+
+		err = rows.Scan(&email)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"sync"
+	"time"
+)
+
+// CaughtMessage is an email kept by the CatcherMailer
+type CaughtMessage struct {
+	ID        int64     `json:"id"`
+	SentAt    time.Time `json:"sent_at"`
+	To        string    `json:"to"`
+	From      string    `json:"from"`
+	Subject   string    `json:"subject"`
+	Template  string    `json:"template"`
+	Locale    string    `json:"locale"`
+	PlainBody string    `json:"plain_body"`
+	HTMLBody  string    `json:"html_body"`
+}
+
+// CatcherMailer keeps the most recent emails in a ring buffer instead of sending them, so that in development they can
+// be read back, e.g. to copy a token, without any mail leaving the machine
+type CatcherMailer struct {
+	mu       sync.Mutex
+	messages []CaughtMessage // ring buffer, next is the index the next email is written to
+	next     int
+	full     bool
+	lastID   int64
+	sender   string
+}
+
+// NewCatcher returns a CatcherMailer which keeps the last size emails
+func NewCatcher(sender string, size int) *CatcherMailer {
+	if size < 1 {
+		size = 1
+	}
+
+	return &CatcherMailer{messages: make([]CaughtMessage, size), sender: sender}
+}
+
+// Send renders the email and keeps it, replacing the oldest email if the buffer is full
+func (m *CatcherMailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+
+	m.messages[m.next] = CaughtMessage{
+		ID:        m.lastID,
+		SentAt:    time.Now(),
+		To:        msg.To,
+		From:      msg.From,
+		Subject:   msg.Subject,
+		Template:  msg.Template,
+		Locale:    msg.Locale,
+		PlainBody: msg.PlainBody,
+		HTMLBody:  msg.HTMLBody,
+	}
+
+	m.next = (m.next + 1) % len(m.messages)
+	if m.next == 0 {
+		m.full = true
+	}
+
+	return nil
+}
+
+// Messages returns a copy of the emails kept, newest first
+func (m *CatcherMailer) Messages() []CaughtMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := m.next
+	if m.full {
+		count = len(m.messages)
+	}
+
+	messages := make([]CaughtMessage, 0, count)
+
+	for i := 1; i <= count; i++ {
+		messages = append(messages, m.messages[(m.next-i+len(m.messages))%len(m.messages)])
+	}
+
+	return messages
+}
+
+// Get returns the email with the given ID, if it's still kept
+func (m *CatcherMailer) Get(id int64) (CaughtMessage, bool) {
+	for _, msg := range m.Messages() {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+
+	return CaughtMessage{}, false
+}
+
+// Reset forgets the emails kept so far. IDs carry on from where they were, so an old link doesn't show a new email.
+func (m *CatcherMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = make([]CaughtMessage, len(m.messages))
+	m.next = 0
+	m.full = false
+}
@@ -0,0 +1,160 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// the data each template is rendered with. The templates are checked against these when they're parsed, so a template
+// referencing a field which doesn't exist stops the program starting instead of rendering an empty value.
+
+// WelcomeData is the data for user_welcome.html
+type WelcomeData struct {
+	Name            string
+	UserID          int64
+	ActivationToken string
+}
+
+// ActivationData is the data for token_activation.html
+type ActivationData struct {
+	Name            string
+	ActivationToken string
+}
+
+// PasswordResetData is the data for token_password_reset.html
+type PasswordResetData struct {
+	PasswordResetToken string
+}
+
+// AdminPasswordResetData is the data for admin_password_reset.html
+type AdminPasswordResetData struct {
+	Name               string
+	PasswordResetToken string
+}
+
+// MagicLinkData is the data for token_magic_link.html
+type MagicLinkData struct {
+	Name           string
+	MagicLinkToken string
+}
+
+// EmailChangeData is the data for token_email_change.html, sent to the new address
+type EmailChangeData struct {
+	Name             string
+	NewEmail         string
+	EmailChangeToken string
+}
+
+// EmailChangeNoticeData is the data for email_change_notice.html, sent to the old address
+type EmailChangeNoticeData struct {
+	Name     string
+	NewEmail string
+}
+
+// DataExportData is the data for token_data_export.html
+type DataExportData struct {
+	Name            string
+	DataExportToken string
+}
+
+// AccountLockedData is the data for account_locked.html
+type AccountLockedData struct {
+	Name        string
+	IP          string
+	LockedUntil string
+}
+
+// AccountDeletionScheduledData is the data for account_deletion_scheduled.html
+type AccountDeletionScheduledData struct {
+	Name              string
+	DeletionScheduled string
+}
+
+// InvitationData is the data for invitation.html
+type InvitationData struct {
+	InviterName     string
+	InvitationToken string
+	Expiry          string
+}
+
+// SecurityDetails describes the request which triggered a security notification, along with a token the recipient can
+// use to sign out of every session if it wasn't them
+type SecurityDetails struct {
+	Time                string
+	IP                  string
+	UserAgent           string
+	RevokeSessionsToken string
+}
+
+// PasswordChangedData is the data for password_changed.html
+type PasswordChangedData struct {
+	Name string
+	SecurityDetails
+}
+
+// NewSignInData is the data for new_sign_in.html
+type NewSignInData struct {
+	Name   string
+	Method string
+	SecurityDetails
+}
+
+// PermissionsGrantedData is the data for permissions_granted.html
+type PermissionsGrantedData struct {
+	Name        string
+	Permissions string
+	SecurityDetails
+}
+
+// contracts maps each template file to the type of the data it is rendered with. Localized variants share the
+// contract of the template they translate.
+var contracts = map[string]reflect.Type{
+	"user_welcome.html":               reflect.TypeOf(WelcomeData{}),
+	"token_activation.html":           reflect.TypeOf(ActivationData{}),
+	"token_password_reset.html":       reflect.TypeOf(PasswordResetData{}),
+	"admin_password_reset.html":       reflect.TypeOf(AdminPasswordResetData{}),
+	"token_magic_link.html":           reflect.TypeOf(MagicLinkData{}),
+	"token_email_change.html":         reflect.TypeOf(EmailChangeData{}),
+	"email_change_notice.html":        reflect.TypeOf(EmailChangeNoticeData{}),
+	"token_data_export.html":          reflect.TypeOf(DataExportData{}),
+	"account_locked.html":             reflect.TypeOf(AccountLockedData{}),
+	"account_deletion_scheduled.html": reflect.TypeOf(AccountDeletionScheduledData{}),
+	"invitation.html":                 reflect.TypeOf(InvitationData{}),
+	"password_changed.html":           reflect.TypeOf(PasswordChangedData{}),
+	"new_sign_in.html":                reflect.TypeOf(NewSignInData{}),
+	"permissions_granted.html":        reflect.TypeOf(PermissionsGrantedData{}),
+}
+
+// checkData returns an error if data isn't the type the template is rendered with
+func checkData(templateFile string, data interface{}) error {
+	contract, ok := contracts[templateFile]
+	if !ok {
+		return fmt.Errorf("mailer: unknown template %q", templateFile)
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(data))
+
+	if !value.IsValid() || value.Type() != contract {
+		return fmt.Errorf("mailer: template %q must be rendered with %s, not %T", templateFile, contract, data)
+	}
+
+	return nil
+}
+
+// DecodeData decodes JSON encoded template data, e.g. from the email outbox, into the type the template is rendered with
+func DecodeData(templateFile string, js []byte) (interface{}, error) {
+	contract, ok := contracts[templateFile]
+	if !ok {
+		return nil, fmt.Errorf("mailer: unknown template %q", templateFile)
+	}
+
+	data := reflect.New(contract)
+
+	err := json.Unmarshal(js, data.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return data.Elem().Interface(), nil
+}
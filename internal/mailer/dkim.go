@@ -0,0 +1,296 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are the header fields signed when they're present in the message. From is listed twice so that a
+// second From header can't be added without breaking the signature.
+var dkimHeaders = []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID", "Mime-Version", "Content-Type", "From"}
+
+// DKIMSigner adds a DKIM signature (RFC 6376) to outgoing messages, using relaxed/relaxed canonicalization and either
+// rsa-sha256 or ed25519-sha256 (RFC 8463) depending on the key. The public key must be published in DNS at
+// <selector>._domainkey.<domain>.
+type DKIMSigner struct {
+	domain    string
+	selector  string
+	key       crypto.Signer
+	algorithm string
+	now       func() time.Time
+}
+
+// NewDKIMSigner returns a signer for the domain and selector. The key must be an *rsa.PrivateKey or an ed25519.PrivateKey.
+func NewDKIMSigner(domain, selector string, key crypto.Signer) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: domain and selector must be provided")
+	}
+
+	signer := &DKIMSigner{
+		domain:   domain,
+		selector: selector,
+		key:      key,
+		now:      time.Now,
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 1024 {
+			return nil, errors.New("dkim: RSA keys must be at least 1024 bits")
+		}
+		signer.algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		signer.algorithm = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// LoadDKIMSigner returns a signer using the PEM encoded private key in keyFile, either a PKCS #1 RSA key or a PKCS #8
+// RSA or Ed25519 key
+func LoadDKIMSigner(domain, selector, keyFile string) (*DKIMSigner, error) {
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseDKIMKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDKIMSigner(domain, selector, key)
+}
+
+// ParseDKIMKey parses a PEM encoded PKCS #1 RSA private key, or a PKCS #8 RSA or Ed25519 private key
+func ParseDKIMKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM encoded private key found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+		}
+
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("dkim: unsupported PEM block type %q", block.Type)
+	}
+}
+
+// Sign returns the message with a DKIM-Signature header added to the top. The message must be in RFC 5322 format.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	message = toCRLF(message)
+
+	header, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return nil, errors.New("dkim: message has no body")
+	}
+
+	fields := parseHeaderFields(append(header, "\r\n"...))
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	if !hasHeaderField(fields, "From") {
+		return nil, errors.New("dkim: message has no From header")
+	}
+
+	// only the headers in the message are signed
+	var signed []string
+	for _, name := range dkimHeaders {
+		if hasHeaderField(fields, name) {
+			signed = append(signed, name)
+		}
+	}
+
+	sigHeader := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n t=%d; h=%s;\r\n bh=%s;\r\n b=",
+		s.algorithm, s.domain, s.selector, s.now().Unix(), strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	signature, err := s.sign(dkimSigningInput(fields, signed, sigHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigHeader)
+	out.WriteString(foldBase64(base64.StdEncoding.EncodeToString(signature)))
+	out.WriteString("\r\n")
+	out.Write(message)
+
+	return out.Bytes(), nil
+}
+
+// sign signs the SHA-256 hash of data. Ed25519 signs the hash itself rather than the data (RFC 8463 section 3).
+func (s *DKIMSigner) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if s.algorithm == "ed25519-sha256" {
+		opts = crypto.Hash(0)
+	}
+
+	return s.key.Sign(rand.Reader, digest[:], opts)
+}
+
+// headerField is a header field as it appears in the message, including folding and the trailing CRLF
+type headerField struct {
+	name string
+	raw  string
+}
+
+// parseHeaderFields splits a message header into its fields, keeping continuation lines with their field
+func parseHeaderFields(header []byte) []headerField {
+	var fields []headerField
+
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+
+	return fields
+}
+
+// hasHeaderField reports whether there is a header field with the name, which is case-insensitive
+func hasHeaderField(fields []headerField, name string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dkimSigningInput returns the data the signature is calculated over: the signed header fields, picked from the bottom
+// of the header up when a name is listed more than once, followed by the DKIM-Signature header with an empty b= tag
+// and without its trailing CRLF (RFC 6376 section 3.7). A listed field which isn't present contributes nothing.
+func dkimSigningInput(fields []headerField, signed []string, sigHeader string) []byte {
+	var input bytes.Buffer
+
+	used := make([]bool, len(fields))
+
+	for _, name := range signed {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				input.WriteString(relaxedHeader(fields[i].raw))
+				break
+			}
+		}
+	}
+
+	input.WriteString(strings.TrimSuffix(relaxedHeader(sigHeader), "\r\n"))
+
+	return input.Bytes()
+}
+
+// relaxedHeader canonicalizes a header field with the relaxed algorithm (RFC 6376 section 3.4.2): the name is
+// lowercased, the value is unfolded, runs of whitespace become a single space, and whitespace around the colon and at
+// the end of the value is removed
+func relaxedHeader(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(compressWSP(value))
+
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// relaxedBody canonicalizes a message body with the relaxed algorithm (RFC 6376 section 3.4.4): whitespace at the end
+// of lines is removed, runs of whitespace become a single space, and empty lines at the end of the body are removed
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(compressWSP(line), " ")
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// compressWSP replaces each run of spaces and tabs with a single space
+func compressWSP(s string) string {
+	var b strings.Builder
+
+	inWSP := false
+
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			if !inWSP {
+				b.WriteByte(' ')
+			}
+			inWSP = true
+			continue
+		}
+
+		inWSP = false
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// toCRLF converts the line endings of a message to CRLF
+func toCRLF(message []byte) []byte {
+	message = bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(message, []byte("\n"), []byte("\r\n"))
+}
+
+// foldBase64 folds a base64 value onto continuation lines, which verifiers ignore when decoding it
+func foldBase64(s string) string {
+	const width = 72
+
+	var b strings.Builder
+
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\r\n ")
+		s = s[width:]
+	}
+
+	b.WriteString(s)
+
+	return b.String()
+}
@@ -0,0 +1,253 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the Ed25519 key and signed message from RFC 8463 appendix A
+const (
+	rfc8463Seed      = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463PublicKey = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+	rfc8463BodyHash  = "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="
+)
+
+var rfc8463Message = strings.ReplaceAll(`DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=brisbane; t=1528637909; h=from : to :
+ subject : date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus
+ Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==
+From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game.  Are you hungry yet?
+
+Joe.
+`, "\n", "\r\n")
+
+// unsignedMessage is the RFC 8463 message without its signature
+func unsignedMessage() []byte {
+	_, message, _ := strings.Cut(rfc8463Message, "Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n")
+	return []byte(message)
+}
+
+func rfc8463Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	seed, err := base64.StdEncoding.DecodeString(rfc8463Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// verifyDKIM checks the first DKIM-Signature header of a message against the public key, independently of Sign
+func verifyDKIM(message []byte, publicKey crypto.PublicKey) error {
+	header, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return errors.New("no body")
+	}
+
+	fields := parseHeaderFields(append(header, "\r\n"...))
+
+	var sig *headerField
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, "DKIM-Signature") {
+			sig = &fields[i]
+			break
+		}
+	}
+
+	if sig == nil {
+		return errors.New("no DKIM-Signature header")
+	}
+
+	tags := map[string]string{}
+
+	_, value, _ := strings.Cut(sig.raw, ":")
+	for _, tag := range strings.Split(value, ";") {
+		name, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(v), "")
+	}
+
+	if tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("unexpected canonicalization %q", tags["c"])
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if got := base64.StdEncoding.EncodeToString(bodyHash[:]); got != tags["bh"] {
+		return fmt.Errorf("body hash %s, want %s", got, tags["bh"])
+	}
+
+	signed := strings.Split(tags["h"], ":")
+
+	// the signature header is signed with the value of its b= tag removed
+	unsigned := regexp.MustCompile(`(;\s*b=)[^;]*`).ReplaceAllString(strings.TrimSuffix(sig.raw, "\r\n"), "$1")
+
+	input := dkimSigningInput(fields, signed, unsigned)
+	digest := sha256.Sum256(input)
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" || !ed25519.Verify(key, digest[:], signature) {
+			return errors.New("invalid ed25519-sha256 signature")
+		}
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return fmt.Errorf("unexpected algorithm %q", tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	default:
+		return fmt.Errorf("unsupported key type %T", publicKey)
+	}
+
+	return nil
+}
+
+func TestRFC8463KeyPair(t *testing.T) {
+	public := rfc8463Key(t).Public().(ed25519.PublicKey)
+
+	if got := base64.StdEncoding.EncodeToString(public); got != rfc8463PublicKey {
+		t.Fatalf("public key %s, want %s", got, rfc8463PublicKey)
+	}
+}
+
+func TestRelaxedBodyHash(t *testing.T) {
+	hash := sha256.Sum256(relaxedBody(unsignedMessage()[bytes.Index(unsignedMessage(), []byte("\r\n\r\n"))+4:]))
+
+	if got := base64.StdEncoding.EncodeToString(hash[:]); got != rfc8463BodyHash {
+		t.Fatalf("body hash %s, want %s", got, rfc8463BodyHash)
+	}
+}
+
+func TestVerifyRFC8463Signature(t *testing.T) {
+	public := rfc8463Key(t).Public()
+
+	err := verifyDKIM([]byte(rfc8463Message), public)
+	if err != nil {
+		t.Fatalf("RFC 8463 signature did not verify: %v", err)
+	}
+
+	tampered := strings.Replace(rfc8463Message, "Subject: Is dinner ready?", "Subject: Is lunch ready?", 1)
+
+	if verifyDKIM([]byte(tampered), public) == nil {
+		t.Fatal("signature verified after the subject was changed")
+	}
+}
+
+func TestSignEd25519(t *testing.T) {
+	signer, err := NewDKIMSigner("football.example.com", "brisbane", rfc8463Key(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer.now = func() time.Time { return time.Unix(1528637909, 0) }
+
+	signed, err := signer.Sign(unsignedMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := string(signed[:bytes.Index(signed, []byte("\r\nFrom:"))])
+
+	for _, want := range []string{"a=ed25519-sha256;", "c=relaxed/relaxed;", "d=football.example.com;", "s=brisbane;", "t=1528637909;", "bh=" + rfc8463BodyHash + ";"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("signature header %q does not contain %q", header, want)
+		}
+	}
+
+	// Ed25519 signatures are deterministic, so signing the same message twice gives the same signature
+	again, err := signer.Sign(unsignedMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(signed, again) {
+		t.Error("signing the same message twice gave different signatures")
+	}
+
+	err = verifyDKIM(signed, rfc8463Key(t).Public())
+	if err != nil {
+		t.Fatalf("signature did not verify: %v", err)
+	}
+
+	// relaxed canonicalization tolerates whitespace changes made in transit
+	reformatted := bytes.Replace(signed, []byte("Subject: Is dinner ready?"), []byte("subject:   Is dinner ready?  "), 1)
+
+	err = verifyDKIM(reformatted, rfc8463Key(t).Public())
+	if err != nil {
+		t.Fatalf("signature did not verify after whitespace changes: %v", err)
+	}
+
+	// adding a second From header breaks the signature, because From is signed twice
+	withExtraFrom := append([]byte("From: Mallory <mallory@example.org>\r\n"), signed...)
+
+	if verifyDKIM(withExtraFrom, rfc8463Key(t).Public()) == nil {
+		t.Fatal("signature verified after a From header was added")
+	}
+}
+
+func TestSignRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewDKIMSigner("example.com", "mail", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a message built by the mailer, with LF line endings to check they're converted
+	msg, err := Render("Greenlight <no-reply@example.com>", "alice@example.net", DefaultLocale, "token_activation.html", ActivationData{Name: "Alice", ActivationToken: "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = msg.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signer.Sign(bytes.ReplaceAll(buf.Bytes(), []byte("\r\n"), []byte("\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifyDKIM(signed, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("signature did not verify: %v", err)
+	}
+
+	tampered := bytes.Replace(signed, []byte("Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"), []byte("AAAAAAAAAAAAAAAAAAAAAAAAAA"), 1)
+
+	if verifyDKIM(tampered, &key.PublicKey) == nil {
+		t.Fatal("signature verified after the body was changed")
+	}
+}
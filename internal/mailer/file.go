@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each email to an .eml file in a directory, which can be opened with any mail client
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+// NewFile creates the directory if needed and returns a FileMailer which writes to it
+func NewFile(dir, sender string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir: dir, sender: sender}, nil
+}
+
+// Send renders the email and writes it to a new file named after the time it was sent
+func (m *FileMailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
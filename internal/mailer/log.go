@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"github.com/blessedmadukoma/greenlight/internal/jsonlog"
+)
+
+// LogMailer writes each email to the application log instead of sending it
+type LogMailer struct {
+	logger *jsonlog.Logger
+	sender string
+}
+
+// NewLog returns a LogMailer which writes to logger
+func NewLog(logger *jsonlog.Logger, sender string) *LogMailer {
+	return &LogMailer{logger: logger, sender: sender}
+}
+
+// Send renders the email and logs it, including the plain text body so that tokens can be copied out of the log
+func (m *LogMailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.logger.PrintInfo("email sent", map[string]string{
+		"to":       msg.To,
+		"from":     msg.From,
+		"subject":  msg.Subject,
+		"template": msg.Template,
+		"locale":   msg.Locale,
+		"body":     msg.PlainBody,
+	})
+
+	return nil
+}
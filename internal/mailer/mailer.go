@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"embed"
 	"html/template"
-	"log"
-	"time"
+	"io"
+	"os"
 
 	"github.com/go-mail/mail/v2"
 )
@@ -13,60 +13,100 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+// Mailer sends the emails rendered from the templates. Each backend decides what "sending" means: SMTP delivers the
+// email, while the others write it to disk, log it or keep it in memory for local development and tests.
+type Mailer interface {
+	// Send takes the recipient email address, the recipient's locale, file name containing the templates, and any
+	// dynamic data for the templates. The localized variant of the templates is used if there is one for the locale.
+	Send(recipient, locale, templateFile string, data interface{}) error
 }
 
-// New initializes a new mail.Dialer instace
-func New(host string, port int, username, password, sender string) Mailer {
+// Message is an email rendered from a template
+type Message struct {
+	To        string
+	From      string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+	Template  string
+	Locale    string
+}
+
+// render executes the subject, plainBody and htmlBody templates in templateFile, localized for the locale, to build a message
+func render(sender, recipient, locale, templateFile string, data interface{}) (*Message, error) {
+	return renderFrom(templates, sender, recipient, locale, templateFile, data)
+}
 
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+// Render builds a message from the embedded templates without sending it, e.g. to preview it
+func Render(sender, recipient, locale, templateFile string, data interface{}) (*Message, error) {
+	return render(sender, recipient, locale, templateFile, data)
+}
 
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+// RenderDir builds a message like Render, but from templates read from dir on every call rather than the embedded ones,
+// so that edits to the templates show up straight away. The templates are validated the same way as the embedded ones.
+func RenderDir(dir, sender, recipient, locale, templateFile string, data interface{}) (*Message, error) {
+	set, err := parseTemplates(os.DirFS(dir))
+	if err != nil {
+		return nil, err
 	}
+
+	return renderFrom(set, sender, recipient, locale, templateFile, data)
 }
 
-// Send() takes the recipient email address, file name containing the templates, and any dynamic data for the templates
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// renderFrom builds a message from the templates in set
+func renderFrom(set map[string]*template.Template, sender, recipient, locale, templateFile string, data interface{}) (*Message, error) {
+	err := checkData(templateFile, data)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := lookup(set, templateFile, locale)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	return &Message{
+		To:        recipient,
+		From:      sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+		Template:  templateFile,
+		Locale:    locale,
+	}, nil
+}
 
-	err = m.dialer.DialAndSend(msg)
-	if err != nil {
-		return err
-	}
+// mimeMessage converts a message to a multipart MIME message
+func (msg *Message) mimeMessage() *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+
+	return m
+}
 
-	log.Println("=> Mail sent!")
-	return nil
+// WriteTo writes the message in RFC 5322 format, i.e. the contents of an .eml file
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	return msg.mimeMessage().WriteTo(w)
 }
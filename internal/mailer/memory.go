@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"sync"
+)
+
+// MemoryMailer records each email in memory, so tests can check what would have been sent
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+	sender   string
+}
+
+// NewMemory returns an empty MemoryMailer
+func NewMemory(sender string) *MemoryMailer {
+	return &MemoryMailer{sender: sender}
+}
+
+// Send renders the email and records it
+func (m *MemoryMailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, *msg)
+
+	return nil
+}
+
+// Messages returns a copy of the emails recorded so far, oldest first
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+
+	return messages
+}
+
+// Reset forgets the emails recorded so far
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
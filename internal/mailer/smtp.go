@@ -0,0 +1,258 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/mail"
+	"sync"
+	"time"
+
+	gomail "github.com/go-mail/mail/v2"
+	"golang.org/x/time/rate"
+)
+
+// smtpIdleTimeout is how long a pooled connection is kept open without sending anything. SMTP servers drop idle
+// connections after a few minutes, so it's closed before then rather than failing on the next send.
+const smtpIdleTimeout = 30 * time.Second
+
+// ErrMailerClosed is returned when sending through a mailer which has been closed
+var ErrMailerClosed = errors.New("mailer: closed")
+
+// SMTPMailer delivers emails through an SMTP server. By default each message is sent on a new connection; Start
+// switches to a pool of long-lived connections with a rate limit, which Close drains.
+type SMTPMailer struct {
+	dialer *gomail.Dialer
+	sender string
+	dkim   *DKIMSigner
+
+	limiter *rate.Limiter
+	queue   chan *smtpRequest
+	mu      sync.RWMutex // held for reading while queueing, and for writing to close the queue
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// smtpRequest is a message waiting to be sent by the pool, and the channel the result is sent back on
+type smtpRequest struct {
+	msg    *Message
+	result chan error
+}
+
+// NewSMTP initializes a new mail.Dialer instace
+func NewSMTP(host string, port int, username, password, sender string) *SMTPMailer {
+
+	dialer := gomail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPMailer{
+		dialer: dialer,
+		sender: sender,
+	}
+}
+
+// SignWith makes the mailer DKIM sign every message it sends
+func (m *SMTPMailer) SignWith(signer *DKIMSigner) {
+	m.dkim = signer
+}
+
+// Start starts concurrency senders, each keeping its own authenticated connection open between messages and
+// reconnecting when it fails, and limits them to ratePerSecond messages per second between them (0 for no limit).
+// Send still returns once the message has been sent, so callers see delivery errors as before.
+func (m *SMTPMailer) Start(ratePerSecond float64, concurrency int) {
+	limit := rate.Inf
+	if ratePerSecond > 0 {
+		limit = rate.Limit(ratePerSecond)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	m.limiter = rate.NewLimiter(limit, 1)
+	m.queue = make(chan *smtpRequest)
+
+	for i := 0; i < concurrency; i++ {
+		m.wg.Add(1)
+		go m.runSender()
+	}
+}
+
+// Close stops the mailer accepting messages, waits for the ones already queued to be sent, and closes the connections
+func (m *SMTPMailer) Close() error {
+	m.mu.Lock()
+
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+
+	m.closed = true
+
+	if m.queue != nil {
+		close(m.queue)
+	}
+
+	m.mu.Unlock()
+
+	m.wg.Wait()
+
+	return nil
+}
+
+// Send renders the email and delivers it through the SMTP server
+func (m *SMTPMailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, locale, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	return m.SendMessage(msg)
+}
+
+// SendMessage delivers an already rendered message through the SMTP server
+func (m *SMTPMailer) SendMessage(msg *Message) error {
+	m.mu.RLock()
+
+	if m.closed {
+		m.mu.RUnlock()
+		return ErrMailerClosed
+	}
+
+	// not started, so dial a connection just for this message
+	if m.queue == nil {
+		m.mu.RUnlock()
+
+		var conn gomail.SendCloser
+		defer func() {
+			if conn != nil {
+				conn.Close()
+			}
+		}()
+
+		return m.deliver(&conn, msg)
+	}
+
+	req := &smtpRequest{msg: msg, result: make(chan error, 1)}
+
+	// the read lock is held until a sender takes the request, so Close can't close the queue under us
+	m.queue <- req
+	m.mu.RUnlock()
+
+	return <-req.result
+}
+
+// runSender sends the queued messages on a connection which is kept open between them, until the queue is closed
+func (m *SMTPMailer) runSender() {
+	defer m.wg.Done()
+
+	var conn gomail.SendCloser
+
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+
+	idle := time.NewTimer(smtpIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case req, ok := <-m.queue:
+			if !ok {
+				return
+			}
+
+			// the limiter has no deadline, so Wait only fails if a single message would exceed the limit
+			_ = m.limiter.Wait(context.Background())
+
+			req.result <- m.deliver(&conn, req.msg)
+
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(smtpIdleTimeout)
+		case <-idle.C:
+			closeConn()
+			idle.Reset(smtpIdleTimeout)
+		}
+	}
+}
+
+// deliver sends a message on *conn, dialing a new connection if there isn't one. A reused connection which has been
+// dropped by the server is replaced and the message sent again; any other failure closes the connection so that the
+// next message starts with a fresh one.
+func (m *SMTPMailer) deliver(conn *gomail.SendCloser, msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	payload, err := m.payload(msg)
+	if err != nil {
+		return err
+	}
+
+	reused := *conn != nil
+
+	for {
+		if *conn == nil {
+			*conn, err = m.dialer.Dial()
+			if err != nil {
+				*conn = nil
+				return err
+			}
+		}
+
+		err = (*conn).Send(from.Address, []string{to.Address}, bytes.NewReader(payload))
+		if err == nil {
+			return nil
+		}
+
+		(*conn).Close()
+		*conn = nil
+
+		if !reused || !isConnectionError(err) {
+			return err
+		}
+
+		reused = false
+	}
+}
+
+// payload returns the message in RFC 5322 format, DKIM signed if the mailer has a signer. The signature covers the exact
+// bytes sent, so the message is written out here rather than letting go-mail write it to the connection.
+func (m *SMTPMailer) payload(msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	_, err := msg.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.dkim == nil {
+		return buf.Bytes(), nil
+	}
+
+	return m.dkim.Sign(buf.Bytes())
+}
+
+// isConnectionError reports whether an error means the connection was lost, rather than the server rejecting the message
+func isConnectionError(err error) bool {
+	var netErr net.Error
+
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
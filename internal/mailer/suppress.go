@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrSuppressed is returned when sending to an address on the suppression list
+var ErrSuppressed = errors.New("mailer: recipient is suppressed")
+
+// SuppressionList reports whether mail to an address should no longer be sent, e.g. because it bounced
+type SuppressionList interface {
+	Suppressed(email string) (bool, error)
+}
+
+// SuppressingMailer wraps a Mailer and refuses to send to the addresses on a suppression list
+type SuppressingMailer struct {
+	mailer Mailer
+	list   SuppressionList
+}
+
+// WithSuppressions returns a Mailer which sends with m, unless the recipient is on list
+func WithSuppressions(m Mailer, list SuppressionList) *SuppressingMailer {
+	return &SuppressingMailer{mailer: m, list: list}
+}
+
+// Send checks the suppression list and sends the email, or returns ErrSuppressed without sending it
+func (m *SuppressingMailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	suppressed, err := m.list.Suppressed(recipient)
+	if err != nil {
+		return err
+	}
+
+	if suppressed {
+		return ErrSuppressed
+	}
+
+	return m.mailer.Send(recipient, locale, templateFile, data)
+}
+
+// Close closes the wrapped mailer if it needs closing
+func (m *SuppressingMailer) Close() error {
+	if closer, ok := m.mailer.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
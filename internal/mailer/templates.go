@@ -0,0 +1,180 @@
+package mailer
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is the locale of the unsuffixed templates, used when there isn't a template for the recipient's locale
+const DefaultLocale = "en"
+
+// templates holds every email template, parsed once when the program starts rather than on every send. They are keyed
+// on the file name, and localized variants are suffixed with their locale, e.g. user_welcome.fr.html.
+var templates = mustParseTemplates()
+
+// mustParseTemplates parses and validates the embedded templates. They are compiled into the binary, so a template which
+// doesn't parse or validate is a bug and the program panics on start up.
+func mustParseTemplates() map[string]*template.Template {
+	fsys, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	parsed, err := parseTemplates(fsys)
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// parseTemplates parses and validates the .html templates in the root of fsys, keyed on their file names
+func parseTemplates(fsys fs.FS) (map[string]*template.Template, error) {
+	files, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make(map[string]*template.Template, len(files))
+
+	for _, file := range files {
+		tmpl, err := template.New(file).ParseFS(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		err = validateTemplate(file, tmpl)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[file] = tmpl
+	}
+
+	return parsed, nil
+}
+
+// Templates returns the file names of the templates which can be sent, sorted
+func Templates() []string {
+	names := make([]string, 0, len(contracts))
+
+	for name := range contracts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// baseName returns the file name of the template a localized variant translates, e.g. user_welcome.html for user_welcome.fr.html
+func baseName(name string) string {
+	parts := strings.Split(strings.TrimSuffix(name, ".html"), ".")
+	return parts[0] + ".html"
+}
+
+// localizedName returns the file name of the variant of templateFile for the locale
+func localizedName(templateFile, locale string) string {
+	return strings.TrimSuffix(templateFile, ".html") + "." + locale + ".html"
+}
+
+// lookup returns the template from set for the locale, trying the locale's base language (fr for fr-ca) and then the
+// default locale if there isn't a variant for it
+func lookup(set map[string]*template.Template, templateFile, locale string) (*template.Template, error) {
+	locale = strings.ToLower(locale)
+
+	candidates := []string{}
+
+	if locale != "" && locale != DefaultLocale {
+		candidates = append(candidates, localizedName(templateFile, locale))
+
+		if base, _, found := strings.Cut(locale, "-"); found {
+			candidates = append(candidates, localizedName(templateFile, base))
+		}
+	}
+
+	candidates = append(candidates, templateFile)
+
+	for _, name := range candidates {
+		if tmpl, ok := set[name]; ok {
+			return tmpl, nil
+		}
+	}
+
+	return nil, fmt.Errorf("mailer: unknown template %q", templateFile)
+}
+
+// Locales returns the locales which have templates, sorted with the default locale first
+func Locales() []string {
+	seen := map[string]bool{DefaultLocale: true}
+	locales := []string{}
+
+	for name := range templates {
+		parts := strings.Split(strings.TrimSuffix(name, ".html"), ".")
+		if len(parts) == 2 && !seen[parts[1]] {
+			seen[parts[1]] = true
+			locales = append(locales, parts[1])
+		}
+	}
+
+	sort.Strings(locales)
+
+	return append([]string{DefaultLocale}, locales...)
+}
+
+// MatchLocale returns the supported locale which best matches an Accept-Language header, or the default locale if none do
+func MatchLocale(acceptLanguage string) string {
+	type preference struct {
+		tag     string
+		quality float64
+	}
+
+	var preferences []preference
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		quality := 1.0
+
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if tag == "" || quality <= 0 {
+			continue
+		}
+
+		preferences = append(preferences, preference{tag: strings.ToLower(tag), quality: quality})
+	}
+
+	// the most preferred languages first, keeping the header's order for equal preferences
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+
+	supported := Locales()
+
+	for _, p := range preferences {
+		if p.tag == "*" {
+			return DefaultLocale
+		}
+
+		base, _, _ := strings.Cut(p.tag, "-")
+
+		for _, locale := range supported {
+			if p.tag == locale || base == locale {
+				return locale
+			}
+		}
+	}
+
+	return DefaultLocale
+}
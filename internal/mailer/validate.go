@@ -0,0 +1,149 @@
+package mailer
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"text/template/parse"
+)
+
+// the templates each email file must define
+var requiredTemplates = []string{"subject", "plainBody", "htmlBody"}
+
+// validateTemplate checks that an email template defines the subject, plainBody and htmlBody templates, and that every
+// field it references exists on the data it is rendered with. Fields are checked in every branch, so a misspelt field
+// in an {{if}} that's rarely taken is caught too. Fields inside {{range}} and {{with}} are relative to a different dot,
+// so they aren't checked.
+func validateTemplate(name string, tmpl *template.Template) error {
+	contract, ok := contracts[baseName(name)]
+	if !ok {
+		return fmt.Errorf("mailer: template %q has no data type in contracts", name)
+	}
+
+	for _, required := range requiredTemplates {
+		if tmpl.Lookup(required) == nil {
+			return fmt.Errorf("mailer: template %q does not define %q", name, required)
+		}
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+
+		err := checkFields(t.Tree.Root, contract)
+		if err != nil {
+			return fmt.Errorf("mailer: template %q, block %q: %w", name, t.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// checkFields checks the fields referenced by a parse tree node against the type of dot
+func checkFields(node parse.Node, dot reflect.Type) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, child := range n.Nodes {
+			err := checkFields(child, dot)
+			if err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkPipe(n.Pipe, dot)
+	case *parse.TemplateNode:
+		return checkPipe(n.Pipe, dot)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, dot, true)
+	case *parse.RangeNode:
+		return checkBranch(&n.BranchNode, dot, false)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, dot, false)
+	}
+
+	return nil
+}
+
+// checkBranch checks the pipeline of an {{if}}, {{range}} or {{with}}, and the bodies which keep the same dot
+func checkBranch(n *parse.BranchNode, dot reflect.Type, sameDot bool) error {
+	err := checkPipe(n.Pipe, dot)
+	if err != nil {
+		return err
+	}
+
+	if sameDot {
+		err = checkFields(n.List, dot)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the else branch of a range or with runs with the original dot
+	return checkFields(n.ElseList, dot)
+}
+
+// checkPipe checks the fields referenced by the arguments of a pipeline
+func checkPipe(pipe *parse.PipeNode, dot reflect.Type) error {
+	if pipe == nil {
+		return nil
+	}
+
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			var err error
+
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				err = checkPath(dot, a.Ident)
+			case *parse.VariableNode:
+				// $ is the data the template was executed with
+				if a.Ident[0] == "$" {
+					err = checkPath(dot, a.Ident[1:])
+				}
+			case *parse.PipeNode:
+				err = checkPipe(a, dot)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPath checks that a chain of field names, e.g. .User.Name, can be evaluated on the type
+func checkPath(typ reflect.Type, path []string) error {
+	for _, field := range path {
+		if typ.Kind() == reflect.Map || typ.Kind() == reflect.Interface {
+			return nil
+		}
+
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+
+		if _, ok := reflect.PointerTo(typ).MethodByName(field); ok {
+			return nil
+		}
+
+		if typ.Kind() != reflect.Struct {
+			return fmt.Errorf("can't evaluate field %s in type %s", field, typ)
+		}
+
+		f, ok := typ.FieldByName(field)
+		if !ok || !f.IsExported() {
+			return fmt.Errorf("can't evaluate field %s in type %s", field, typ)
+		}
+
+		typ = f.Type
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+ id bigserial PRIMARY KEY,
+ email citext NOT NULL,
+ ip text NOT NULL,
+ created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email_idx ON login_attempts (email, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created_at);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+ email citext PRIMARY KEY,
+ locked_until timestamp(0) with time zone NOT NULL,
+ lockout_count integer NOT NULL DEFAULT 1
+);
+
+-- Add the permission required by the admin endpoints.
+INSERT INTO permissions (code)
+ VALUES
+ ('users:admin');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_exports;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS roles (
+ id bigserial PRIMARY KEY,
+ created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ name text UNIQUE NOT NULL,
+ description text NOT NULL DEFAULT '',
+ version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+ role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+ permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+ PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+ user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+ role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+ PRIMARY KEY (user_id, role_id)
+);
+
+-- Add the built-in roles and their permissions.
+INSERT INTO roles (name, description)
+ VALUES
+ ('viewer', 'Can browse the movie catalogue'),
+ ('editor', 'Can browse and edit the movie catalogue'),
+ ('admin', 'Has every permission');
+
+INSERT INTO role_permissions
+ SELECT roles.id, permissions.id FROM roles, permissions
+ WHERE (roles.name = 'viewer' AND permissions.code = 'movies:read')
+ OR (roles.name = 'editor' AND permissions.code IN ('movies:read', 'movies:write'))
+ OR roles.name = 'admin';
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'movies:write:own';
+DROP INDEX IF EXISTS movies_created_by_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
@@ -0,0 +1,12 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+
+-- Add the permission to create movies and change only the ones you created.
+INSERT INTO permissions (code)
+ VALUES
+ ('movies:write:own');
+
+INSERT INTO role_permissions
+ SELECT roles.id, permissions.id FROM roles, permissions
+ WHERE roles.name = 'admin' AND permissions.code = 'movies:write:own';
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+ id text PRIMARY KEY,
+ created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ name text NOT NULL,
+ secret_hash bytea,
+ redirect_uris text[] NOT NULL,
+ scopes text[] NOT NULL,
+ user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+ hash bytea PRIMARY KEY,
+ client_id text NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+ user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+ redirect_uri text NOT NULL,
+ scopes text[] NOT NULL,
+ code_challenge text NOT NULL,
+ expiry timestamp(0) with time zone NOT NULL
+);
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS client_id text REFERENCES oauth_clients ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS oauth_scopes text[];
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated_at timestamp(0) with time zone;
+
+-- the activation time wasn't recorded before. Activating an account deletes its activation tokens, and expired tokens
+-- aren't deleted yet, so any user without one was activated at some point, even if an admin has since deactivated them.
+UPDATE users SET activated_at = created_at
+WHERE activated = true
+OR NOT EXISTS (
+ SELECT 1 FROM tokens
+ WHERE tokens.user_id = users.id AND tokens.scope = 'activation'
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS invitations (
+ id bigserial PRIMARY KEY,
+ created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ email citext NOT NULL,
+ hash bytea UNIQUE NOT NULL,
+ roles text[] NOT NULL DEFAULT '{}',
+ permissions text[] NOT NULL DEFAULT '{}',
+ expiry timestamp(0) with time zone NOT NULL,
+ invited_by bigint REFERENCES users ON DELETE SET NULL,
+ accepted_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS invitations_email_idx ON invitations (email);
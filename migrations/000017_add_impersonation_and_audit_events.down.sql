@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS audit_events;
+ALTER TABLE tokens DROP COLUMN IF EXISTS impersonator_id;
@@ -0,0 +1,13 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS impersonator_id bigint REFERENCES users ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS audit_events (
+ id bigserial PRIMARY KEY,
+ created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ action text NOT NULL,
+ actor_id bigint REFERENCES users ON DELETE SET NULL,
+ user_id bigint REFERENCES users ON DELETE SET NULL,
+ ip text NOT NULL DEFAULT '',
+ details jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS audit_events_prevent_update ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_prevent_update();
+DROP INDEX IF EXISTS audit_events_user_id_idx;
+DROP INDEX IF EXISTS audit_events_actor_id_idx;
+DROP INDEX IF EXISTS audit_events_action_idx;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS user_agent;
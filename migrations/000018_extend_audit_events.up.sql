@@ -0,0 +1,24 @@
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id_idx ON audit_events (user_id);
+
+-- the audit log is append-only: events can be deleted by the retention policy, but never changed, apart from the
+-- ON DELETE SET NULL foreign keys clearing the ids of deleted users
+CREATE OR REPLACE FUNCTION audit_events_prevent_update() RETURNS trigger AS $$
+BEGIN
+ IF NEW.id = OLD.id AND NEW.created_at = OLD.created_at AND NEW.action = OLD.action
+  AND NEW.ip = OLD.ip AND NEW.user_agent = OLD.user_agent AND NEW.details = OLD.details
+  AND (NEW.actor_id IS NULL OR NEW.actor_id = OLD.actor_id)
+  AND (NEW.user_id IS NULL OR NEW.user_id = OLD.user_id) THEN
+  RETURN NEW;
+ END IF;
+
+ RAISE EXCEPTION 'audit events cannot be modified';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_prevent_update
+BEFORE UPDATE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_prevent_update();
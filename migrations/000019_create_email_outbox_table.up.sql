@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS email_outbox (
+ id bigserial PRIMARY KEY,
+ created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ recipient citext NOT NULL,
+ template text NOT NULL,
+ data jsonb NOT NULL DEFAULT '{}',
+ status text NOT NULL DEFAULT 'pending',
+ attempts integer NOT NULL DEFAULT 0,
+ next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ last_error text NOT NULL DEFAULT '',
+ sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
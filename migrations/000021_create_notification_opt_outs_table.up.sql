@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS notification_opt_outs (
+ user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+ notification text NOT NULL,
+ PRIMARY KEY (user_id, notification)
+);
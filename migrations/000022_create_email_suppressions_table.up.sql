@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS email_suppressions (
+ email citext PRIMARY KEY,
+ created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ reason text NOT NULL,
+ details text NOT NULL DEFAULT ''
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_bounced boolean NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+-- the scrubbed template data can't be restored
@@ -0,0 +1,2 @@
+-- the template data of sent and dead emails can hold tokens, and is no longer kept once an email is finished with
+UPDATE email_outbox SET data = '{}' WHERE status IN ('sent', 'dead') AND data != '{}';